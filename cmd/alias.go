@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrVersionRequired    = errors.New("a version is required, run it as 'name@version'")
+	ErrVersionNotAccepted = errors.New("url does not accept a version")
+)
+
+// Alias is a named target. In a config an alias may be written as just its url, or as a mapping when it needs any of
+// the other settings.
+type Alias struct {
+	// Url may be a template using '{{.Version}}', '{{.OS}}', and '{{.Arch}}', which is expanded when the alias is run
+	// as 'name@version'.
+	Url string
+
+	// Args are passed to the executable before any arguments given on the command line.
+	Args []string `yaml:",omitempty"`
+
+	// Env is added to the environment the executable is run with.
+	Env map[string]string `yaml:",omitempty"`
+
+	// Interpreter is the command the executable is run with, ie "python3 -u".
+	Interpreter string `yaml:",omitempty"`
+
+	// InvalidateAfter overrides the config's DefaultInvalidateAfter for this alias.
+	InvalidateAfter fan.Duration `yaml:",omitempty"`
+
+	// Sha256 is the expected digest of the executable.
+	Sha256 string `yaml:",omitempty"`
+
+	Description string `yaml:",omitempty"`
+
+	// Tags are keywords the alias can be found by with 'fan search'.
+	Tags []string `yaml:",omitempty"`
+
+	// base is the url of the catalog the alias was read from, which relative urls are resolved against.
+	base *url.URL
+}
+
+// isBare reports whether the alias has no settings other than its url.
+func (a Alias) isBare() bool {
+	return len(a.Args) == 0 && len(a.Env) == 0 && a.Interpreter == "" && a.InvalidateAfter == 0 && a.Sha256 == "" &&
+		a.Description == "" && len(a.Tags) == 0
+}
+
+func (a Alias) MarshalYAML() (interface{}, error) {
+	if a.isBare() {
+		return a.Url, nil
+	}
+
+	type alias Alias
+	return alias(a), nil
+}
+
+func (a *Alias) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*a = Alias{}
+		return node.Decode(&a.Url)
+	}
+
+	type alias Alias
+	return node.Decode((*alias)(a))
+}
+
+// urlValues are the values available to an alias url template.
+type urlValues struct {
+	version     string
+	usedVersion bool
+}
+
+// Version is the version the alias was run with.
+func (v *urlValues) Version() string {
+	v.usedVersion = true
+	return v.version
+}
+
+// OS is the operating system fan is running on, as reported by runtime.GOOS.
+func (v *urlValues) OS() string {
+	return runtime.GOOS
+}
+
+// Arch is the architecture fan is running on, as reported by runtime.GOARCH.
+func (v *urlValues) Arch() string {
+	return runtime.GOARCH
+}
+
+// IsTemplate reports whether the alias url is a template which must be expanded before it can be fetched.
+func (a Alias) IsTemplate() bool {
+	return strings.Contains(a.Url, "{{")
+}
+
+func (a Alias) urlTemplate() (*template.Template, error) {
+	tmpl, err := template.New("url").Option("missingkey=error").Parse(a.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// Expand returns the alias with its url template executed for the given version and the running host. It is an error
+// to give a version to an alias whose url does not use it.
+func (a Alias) Expand(version string) (Alias, error) {
+	tmpl, err := a.urlTemplate()
+	if err != nil {
+		return Alias{}, err
+	}
+
+	values := &urlValues{version: version}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, values); err != nil {
+		return Alias{}, fmt.Errorf("failed to expand url: %w", err)
+	}
+
+	switch {
+	case values.usedVersion && version == "":
+		return Alias{}, ErrVersionRequired
+	case !values.usedVersion && version != "":
+		return Alias{}, ErrVersionNotAccepted
+	}
+
+	a.Url = b.String()
+
+	return a, nil
+}
+
+// ResolvedUrl returns the normalized url of the alias, pinned to its digest if it has one.
+func (a Alias) ResolvedUrl() (string, error) {
+	u := fan.NormalizeUrl(a.Url)
+
+	if a.base != nil && (strings.HasPrefix(u, "./") || strings.HasPrefix(u, "../")) {
+		ref, err := url.Parse(u)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse url: %w", err)
+		}
+
+		u = a.base.ResolveReference(ref).String()
+	}
+
+	if a.Sha256 == "" {
+		return u, nil
+	}
+
+	return fan.WithSha256(u, a.Sha256)
+}
+
+// Command returns the command which runs executable for the alias with the given arguments.
+func (a Alias) Command(ctx context.Context, executable string, args []string) *exec.Cmd {
+	args = append(append([]string{}, a.Args...), args...)
+
+	var cmd *exec.Cmd
+	if interpreter := strings.Fields(a.Interpreter); len(interpreter) > 0 {
+		cmd = exec.CommandContext(ctx, interpreter[0], append(append(interpreter[1:], executable), args...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, executable, args...)
+	}
+
+	if len(a.Env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range a.Env {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+
+	return cmd
+}
+
+// details returns the settings of the alias other than its url as sorted "key: value" pairs.
+func (a Alias) details() []string {
+	var details []string
+
+	if a.Description != "" {
+		details = append(details, "description: "+a.Description)
+	}
+
+	if len(a.Tags) > 0 {
+		details = append(details, "tags: "+strings.Join(a.Tags, ", "))
+	}
+
+	if len(a.Args) > 0 {
+		details = append(details, "args: "+strings.Join(a.Args, " "))
+	}
+
+	if len(a.Env) > 0 {
+		env := make([]string, 0, len(a.Env))
+		for key, value := range a.Env {
+			env = append(env, key+"="+value)
+		}
+		sort.Strings(env)
+
+		details = append(details, "env: "+strings.Join(env, " "))
+	}
+
+	if a.Interpreter != "" {
+		details = append(details, "interpreter: "+a.Interpreter)
+	}
+
+	if a.InvalidateAfter != 0 {
+		details = append(details, "invalidate after: "+a.InvalidateAfter.String())
+	}
+
+	if a.Sha256 != "" {
+		details = append(details, "sha256: "+a.Sha256)
+	}
+
+	return details
+}
+
+// parseEnv parses a list of KEY=VALUE pairs.
+func parseEnv(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	env := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid environment variable '%s', expected KEY=VALUE", pair)
+		}
+
+		env[key] = value
+	}
+
+	return env, nil
+}
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	fan "github.com/joshmeranda/fan/pkg"
 	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/joshmeranda/fan/pkg/trust"
 	"github.com/urfave/cli/v2"
 	"gopkg.in/yaml.v3"
 )
@@ -19,6 +23,13 @@ var (
 	fanCache cache.Cache
 
 	config Config
+
+	// configLayers is the config along with the layer each of its values came from.
+	configLayers LayeredConfig
+
+	lockfile Lockfile
+
+	fanfile Fanfile
 )
 
 func setup(ctx *cli.Context) error {
@@ -26,73 +37,268 @@ func setup(ctx *cli.Context) error {
 		Level: slog.LevelDebug,
 	}))
 
-	switch configPath := ctx.String("config"); configPath {
-	case "":
-		config = DefaultConfig()
-	default:
-		data, err := os.ReadFile(configPath)
+	projectConfigPath, err := findProjectConfig()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
 
-		if errors.Is(err, os.ErrNotExist) {
-			config = DefaultConfig()
-		} else if err != nil {
-			return cli.Exit("failed to read config: "+err.Error(), 1)
-		} else {
-			if err := yaml.Unmarshal(data, &config); err != nil {
-				return cli.Exit("failed to parse config: "+err.Error(), 1)
-			}
+	if configLayers, err = LoadConfig(ctx.String("config"), projectConfigPath, os.Environ()); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	config = configLayers.Config
+
+	fan.DefaultRegistry.Register("file", &fan.FileFetcher{
+		Symlink: config.SymlinkLocalTargets,
+	})
+
+	fan.DefaultRegistry.Register("s3", &fan.S3Fetcher{
+		Region:   config.S3.Region,
+		Endpoint: config.S3.Endpoint,
+		Profile:  config.S3.Profile,
+	})
+
+	fan.DefaultRegistry.Register("oci", &fan.OCIFetcher{
+		Username:  config.OCI.Username,
+		Password:  config.OCI.Password,
+		PlainHTTP: config.OCI.PlainHTTP,
+	})
+
+	sftpFetcher := &fan.SFTPFetcher{
+		User:            config.SSH.User,
+		IdentityFiles:   config.SSH.IdentityFiles,
+		KnownHostsFiles: config.SSH.KnownHostsFiles,
+	}
+	fan.DefaultRegistry.Register("sftp", sftpFetcher)
+	fan.DefaultRegistry.Register("scp", sftpFetcher)
+
+	keys, err := trust.ParseKeys(config.Trust.Keys)
+	if err != nil {
+		return cli.Exit("failed to load trusted keys: "+err.Error(), 1)
+	}
+
+	if config.Trust.Keyring != "" {
+		if keys.OpenPGP, err = trust.LoadKeyring(config.Trust.Keyring); err != nil {
+			return cli.Exit("failed to load trusted keys: "+err.Error(), 1)
 		}
 	}
 
+	if requireSignature := config.Trust.RequireSignature || ctx.Bool("require-signature"); !keys.Empty() || requireSignature {
+		fan.DefaultRegistry.SetVerifier(&trust.Verifier{
+			Registry:         fan.DefaultRegistry,
+			Keys:             keys,
+			SSHNamespace:     config.Trust.SSHNamespace,
+			RequireSignature: requireSignature,
+		})
+	} else {
+		fan.DefaultRegistry.SetVerifier(nil)
+	}
+
+	// catalogs are loaded lazily from the cache created below
+	catalogs = nil
+
+	if fanfile, err = loadFanfile(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	// projects keep their lockfile next to their fanfile
+	lockPath := ctx.String("lockfile")
+	if !ctx.IsSet("lockfile") && fanfile.Path != "" {
+		lockPath = filepath.Join(filepath.Dir(fanfile.Path), DefaultLockFileName)
+	}
+
+	if lockfile, err = ReadLockfile(lockPath); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
 	if config.CacheDir == "" {
 		log.Debug("no cache specified, using noop cache")
 		fanCache = cache.NewNoopCache()
 	} else {
-		fanCache = cache.NewDiskCache(config.CacheDir)
+		fanCache = cache.NewDiskCacheWithMaxSize(config.CacheDir, config.MaxSize)
+
+		if !cache.LockingSupported {
+			log.Debug("file locks are not supported on this platform, the cache is not safe to use from several processes at once")
+		}
 	}
 
 	return nil
 }
 
-func actionRun(ctx *cli.Context) error {
-	if ctx.NArg() == 0 {
-		return cli.Exit("no target specified", 1)
+// named returns the script or alias called name. Scripts declared in the fanfile take precedence over aliases.
+func named(name string) (Alias, bool) {
+	if script, found := fanfile.Scripts[name]; found {
+		return script, true
 	}
 
-	raw := ctx.Args().First()
-	args := ctx.Args().Tail()
+	alias, found := config.Aliases[name]
+	return alias, found
+}
 
-	url := raw
+// splitVersion splits a 'name@version' reference to an alias found by named into its name and version. References
+// which name an alias as a whole, or which do not name one at all, are returned without a version.
+func splitVersion(ref string, named func(string) (Alias, bool)) (string, string) {
+	if _, found := named(ref); found {
+		return ref, ""
+	}
 
-	if unaliased, found := config.Aliases[raw]; found {
-		url = unaliased
+	i := strings.LastIndex(ref, "@")
+	if i <= 0 {
+		return ref, ""
 	}
 
-	_, executable, err := fanCache.GetTargetForUrl(url)
-	if errors.Is(err, cache.ErrNotFound) {
-		log.Debug("target not in cache, pulling...")
+	if _, found := named(ref[:i]); !found {
+		return ref, ""
+	}
 
-		target := fan.Target{
-			Url:             url,
-			InvalidateAfter: config.DefaultInvalidateAfter,
-		}
+	return ref[:i], ref[i+1:]
+}
 
-		tmpExecutable, err := fan.Fetch(url)
+// lookup returns the script, alias, or '<catalog>/<alias>' referenced by ref, with its url template expanded for any
+// version given as 'name@version'. References to a subscribed catalog are always found, even if the catalog cannot be
+// loaded.
+func lookup(ref string) (Alias, bool, error) {
+	find := named
+
+	catalogName, rest, inCatalog := catalogRef(ref)
+	if inCatalog {
+		catalog, err := loadCatalog(catalogName)
 		if err != nil {
-			return cli.Exit("failed to fetch executable for target: "+err.Error(), 1)
+			return Alias{}, true, err
 		}
 
-		if err := fanCache.AddTarget(target, tmpExecutable); err != nil {
-			return cli.Exit("failed to add the target to the cache: "+err.Error(), 1)
+		find, ref = catalog.named, rest
+	}
+
+	name, version := splitVersion(ref, find)
+
+	alias, found := find(name)
+	if !found && inCatalog {
+		return Alias{}, true, fmt.Errorf("catalog '%s' has no alias '%s'", catalogName, name)
+	} else if !found {
+		return Alias{}, false, nil
+	}
+
+	if !alias.IsTemplate() && version == "" {
+		return alias, true, nil
+	}
+
+	expanded, err := alias.Expand(version)
+	if err != nil {
+		return Alias{}, true, fmt.Errorf("invalid alias '%s': %w", name, err)
+	}
+
+	return expanded, true, nil
+}
+
+// resolveUrl returns the url for the given script or alias, or raw itself if it is neither.
+func resolveUrl(raw string) (string, error) {
+	alias, found, err := lookup(raw)
+	if err != nil {
+		return "", err
+	}
+
+	if !found {
+		return fan.NormalizeUrl(raw), nil
+	}
+
+	u, err := alias.ResolvedUrl()
+	if err != nil {
+		return "", fmt.Errorf("invalid alias '%s': %w", raw, err)
+	}
+
+	return u, nil
+}
+
+// cachedUrl returns the url the target at url is cached under, which may refer to the content it currently resolves to
+// rather than url itself.
+func cachedUrl(url string) (string, error) {
+	resolved, err := fan.DefaultRegistry.Resolve(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s': %w", url, err)
+	}
+
+	return resolved, nil
+}
+
+func actionRun(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.Exit("no target specified", 1)
+	}
+
+	url, locked, err := lockedUrl(ctx.Args().First())
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	// any error looking up the alias was already returned when resolving its url
+	alias, _, _ := lookup(ctx.Args().First())
+
+	// an explicit entrypoint or digest may only select the content which was locked
+	if locked != nil {
+		if err := locked.checkSelection(ctx.Args().First(), ctx.String("entrypoint"), ctx.String("sha256")); err != nil {
+			return cli.Exit(err.Error(), 1)
 		}
+	}
 
-		if _, executable, err = fanCache.GetTargetForUrl(url); err != nil {
-			return cli.Exit("failed to get new target from cache: "+err.Error(), 1)
+	if entrypoint := ctx.String("entrypoint"); entrypoint != "" {
+		if url, err = fan.WithEntrypoint(url, entrypoint); err != nil {
+			return cli.Exit(err.Error(), 1)
 		}
+	}
+
+	if digest := ctx.String("sha256"); digest != "" {
+		if url, err = fan.WithSha256(url, digest); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+
+	target := fan.Target{
+		Url:             url,
+		InvalidateAfter: time.Duration(config.DefaultInvalidateAfter),
+	}
+
+	if alias.InvalidateAfter != 0 {
+		target.InvalidateAfter = time.Duration(alias.InvalidateAfter)
+	}
+
+	// accepted digests are kept apart from the cache so changes are still noticed once the previous content is gone
+	confirmer := &changeConfirmer{
+		store:  trust.NewStore(filepath.Join(filepath.Dir(ctx.String("config")), DefaultTrustStoreName)),
+		url:    url,
+		accept: ctx.Bool("accept-changes"),
+		prompt: promptConfirmChange(ctx.App.Reader, ctx.App.ErrWriter),
+	}
+
+	target, executable, err := cache.FetchTarget(fanCache, fan.DefaultRegistry, target, confirmer.confirm)
+	if errors.Is(err, cache.ErrConflict) {
+		return cli.Exit(fmt.Sprintf("%s, run it again to fetch the latest target", err), 1)
 	} else if err != nil {
-		return cli.Exit("failed to get target from cache: "+err.Error(), 1)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	if target.NoStore {
+		defer fanCache.InvalidateUrl(target.Url)
+	}
+
+	if err := confirmer.check(target, executable); err != nil {
+		return cli.Exit(fmt.Sprintf("%s: %s", cache.ErrChangeRejected, err), 1)
 	}
 
-	cmd := exec.CommandContext(ctx.Context, executable, args...)
+	// targets cached before signatures were required are never verified, so they need to be fetched again
+	if (config.Trust.RequireSignature || ctx.Bool("require-signature")) && target.Signature == nil {
+		return cli.Exit(fmt.Sprintf("target '%s' is not signed by a trusted key, if it was cached before signatures were required run 'fan cache invalidate' to fetch and verify it again", url), 1)
+	}
+
+	if locked != nil {
+		if info, err := os.Stat(executable); err != nil {
+			return cli.Exit("failed to stat executable: "+err.Error(), 1)
+		} else if info.Size() != locked.Size {
+			return cli.Exit(fmt.Sprintf("executable is %d bytes but was locked at %d bytes", info.Size(), locked.Size), 1)
+		}
+	}
+
+	cmd := alias.Command(ctx.Context, executable, ctx.Args().Tail())
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
@@ -109,6 +315,17 @@ func actionCacheClean(ctx *cli.Context) error {
 		return cli.Exit("failed to clean cache: "+err.Error(), 1)
 	}
 
+	if raw := ctx.String("max-size"); raw != "" {
+		maxSize, err := fan.ParseByteSize(raw)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+
+		if err := fanCache.Evict(maxSize); err != nil {
+			return cli.Exit("failed to evict targets from cache: "+err.Error(), 1)
+		}
+	}
+
 	return nil
 }
 
@@ -128,13 +345,13 @@ func actionCacheInvalidate(ctx *cli.Context) error {
 		return cli.Exit("no target specified", 1)
 	}
 
-	target := fan.Target{
-		Url: ctx.Args().First(),
+	url, err := resolveUrl(ctx.Args().First())
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
 	}
 
-	url := ctx.Args().First()
-	if unaliased, found := config.Aliases[target.Url]; found {
-		url = unaliased
+	if url, err = cachedUrl(url); err != nil {
+		return cli.Exit(err.Error(), 1)
 	}
 
 	if err := fanCache.InvalidateUrl(url); err != nil {
@@ -149,43 +366,95 @@ func actionAliasAdd(ctx *cli.Context) error {
 		return cli.Exit("expected alais and url", 1)
 	}
 
-	alias := ctx.Args().First()
-	url := ctx.Args().Get(1)
+	name := ctx.Args().First()
+
+	env, err := parseEnv(ctx.StringSlice("env"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	alias := Alias{
+		Url:         fan.NormalizeUrl(ctx.Args().Get(1)),
+		Args:        ctx.StringSlice("arg"),
+		Env:         env,
+		Interpreter: ctx.String("interpreter"),
+		Description: ctx.String("description"),
+		Tags:        ctx.StringSlice("tag"),
+	}
+
+	if raw := ctx.String("invalidate-after"); raw != "" {
+		if alias.InvalidateAfter, err = fan.ParseDuration(raw); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
 
-	config.Aliases[alias] = url
+	if digest := ctx.String("sha256"); digest != "" {
+		if alias.Sha256, err = fan.ParseSha256(digest); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
 
-	if !ctx.Bool("force") {
+	var url string
+	if alias.IsTemplate() {
+		// templates can only be fetched once they are given a version, so only check that they are valid
+		if _, err := alias.urlTemplate(); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	} else if url, err = alias.ResolvedUrl(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	config.Aliases[name] = alias
+
+	if !ctx.Bool("force") && url != "" {
 		p, err := fan.Fetch(url)
 		if err != nil {
 			return fmt.Errorf("failed to fetch url '%s': %w", url, err)
 		}
-		defer os.Remove(p)
+		defer os.RemoveAll(p)
 	}
 
-	data, err := yaml.Marshal(config)
-	if err != nil {
-		return cli.Exit("failed to marshal config: "+err.Error(), 1)
+	var node yaml.Node
+	if err := node.Encode(alias); err != nil {
+		return cli.Exit("failed to marshal alias: "+err.Error(), 1)
 	}
 
-	if err := os.WriteFile(ctx.String("config"), data, 0644); err != nil {
-		return cli.Exit("failed to write config: "+err.Error(), 1)
+	// only the user config is written so values from other layers are not copied into it
+	err = updateConfigFile(ctx.String("config"), func(root *yaml.Node) {
+		setMappingValue(ensureMapping(root, "aliases"), name, &node)
+	})
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
 	}
 
 	return nil
 }
 
 func actionAliasList(ctx *cli.Context) error {
+	aliases := make([]string, 0, len(config.Aliases))
 	maxAliasLen := 0
+
 	for alias := range config.Aliases {
+		aliases = append(aliases, alias)
+
 		if l := len(alias); l > maxAliasLen {
 			maxAliasLen = l
 		}
 	}
 
+	sort.Strings(aliases)
+
 	fmtString := fmt.Sprintf("%% %ds: %%s\n", maxAliasLen)
+	detailPadding := strings.Repeat(" ", maxAliasLen+2)
 
-	for alias, url := range config.Aliases {
-		fmt.Printf(fmtString, alias, url)
+	for _, name := range aliases {
+		alias := config.Aliases[name]
+
+		fmt.Printf(fmtString, name, alias.Url)
+
+		for _, detail := range alias.details() {
+			fmt.Printf("%s%s\n", detailPadding, detail)
+		}
 	}
 
 	return nil
@@ -197,16 +466,109 @@ func actionAliasRemove(ctx *cli.Context) error {
 	}
 
 	for _, alias := range ctx.Args().Slice() {
+		if origin := configLayers.origin("aliases." + alias); origin.Name != LayerUser {
+			log.Warn("alias is not defined in the user config and will not be removed", "alias", alias, "origin", origin.Origin())
+		}
+
 		delete(config.Aliases, alias)
 	}
 
+	err := updateConfigFile(ctx.String("config"), func(root *yaml.Node) {
+		aliases := mappingValue(root, "aliases")
+		if aliases == nil || aliases.Kind != yaml.MappingNode {
+			return
+		}
+
+		for _, alias := range ctx.Args().Slice() {
+			deleteMappingKey(aliases, alias)
+		}
+	})
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	return nil
+}
+
+// printTargetDetails prints the provenance of a cached target.
+func printTargetDetails(target fan.Target) {
+	fmt.Printf("url: %s\n", target.Url)
+	fmt.Printf("cached at: %s\n", target.CachedAt.Format(time.RFC3339))
+
+	if target.Sha256 != "" {
+		fmt.Printf("sha256: %s\n", target.Sha256)
+	}
+
+	if target.Signature == nil {
+		fmt.Println("signature: none")
+		return
+	}
+
+	fmt.Printf("signature: %s (%s)\n", target.Signature.Format, target.Signature.Url)
+	fmt.Printf("signed by: %s\n", target.Signature.KeyID)
+	fmt.Printf("verified at: %s\n", target.Signature.VerifiedAt.Format(time.RFC3339))
+}
+
+func actionConfigShow(ctx *cli.Context) error {
+	if ctx.Bool("origin") {
+		if err := configLayers.WriteOrigins(os.Stdout); err != nil {
+			return cli.Exit("failed to show config: "+err.Error(), 1)
+		}
+
+		return nil
+	}
+
 	data, err := yaml.Marshal(config)
 	if err != nil {
 		return cli.Exit("failed to marshal config: "+err.Error(), 1)
 	}
 
-	if err := os.WriteFile(ctx.String("config"), data, 0644); err != nil {
-		return cli.Exit("failed to write config: "+err.Error(), 1)
+	fmt.Print(string(data))
+
+	return nil
+}
+
+// actionConfigValidate validates each config file without loading it, so that it can report every error in configs
+// which setup would fail to load.
+func actionConfigValidate(ctx *cli.Context) error {
+	paths := ctx.Args().Slice()
+
+	if len(paths) == 0 {
+		projectConfigPath, err := findProjectConfig()
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+
+		for _, path := range []string{SystemConfigPath, ctx.String("config"), projectConfigPath} {
+			if exists, _ := cache.PathExists(path); path != "" && exists {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	valid := true
+
+	for _, path := range paths {
+		if exists, err := cache.PathExists(path); err != nil || !exists {
+			fmt.Printf("%s: no such config\n", path)
+			valid = false
+		} else if _, err := readConfigNode(path); err != nil {
+			fmt.Println(err)
+			valid = false
+		} else {
+			fmt.Printf("%s: ok\n", path)
+		}
+	}
+
+	if layer, found := EnvConfigLayer(os.Environ()); found {
+		if err := ValidateConfigNode(layer.node); err != nil {
+			fmt.Println(configSourceError("environment", err))
+			valid = false
+		}
+	}
+
+	if !valid {
+		return cli.Exit("", 1)
 	}
 
 	return nil
@@ -218,19 +580,27 @@ func actionWhereis(ctx *cli.Context) error {
 	}
 
 	raw := ctx.Args().First()
-	url := raw
 
-	if unaliased, found := config.Aliases[raw]; found {
-		url = unaliased
+	url, err := resolveUrl(raw)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
 	}
 
-	_, executable, err := fanCache.GetTargetForUrl(url)
-	if err != nil {
+	if url, err = cachedUrl(url); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	target, executable, err := fanCache.GetTargetForUrl(url)
+	if err != nil && !errors.Is(err, cache.ErrExpired) {
 		return fmt.Errorf("nothing in cache for '%s'", raw)
 	}
 
 	fmt.Println(executable)
 
+	if ctx.Bool("verbose") {
+		printTargetDetails(target)
+	}
+
 	return nil
 }
 
@@ -242,18 +612,43 @@ func App() cli.App {
 			{
 				Name:      "run",
 				Usage:     "fetch and run a target",
-				UsageText: "fan run <url|alias>",
+				UsageText: "fan run [--entrypoint <path>] [--sha256 <digest>] [--require-signature] [--accept-changes] <url|[catalog/]alias[@version]|script[@version]>",
 				Before:    setup,
 				Action:    actionRun,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "entrypoint",
+						Usage: "the path of the executable to run from an archive target",
+					},
+					&cli.StringFlag{
+						Name:  "sha256",
+						Usage: "the expected sha256 digest of the executable, refusing to run it on mismatch",
+					},
+					&cli.BoolFlag{
+						Name:  "require-signature",
+						Usage: "refuse to run the target unless it is signed by a trusted key",
+					},
+					&cli.BoolFlag{
+						Name:  "accept-changes",
+						Usage: "run the target without confirmation if it changed since it was last run",
+					},
+				},
 			},
 			{
 				Name:   "cache",
 				Before: setup,
 				Subcommands: []*cli.Command{
 					{
-						Name:   "clean",
-						Usage:  "check the cache for expired targets and remove them",
-						Action: actionCacheClean,
+						Name:      "clean",
+						Usage:     "check the cache for expired targets and remove them",
+						UsageText: "fan cache clean [--max-size <size>]",
+						Action:    actionCacheClean,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "max-size",
+								Usage: "also evict the least recently used targets until the cache is no larger than this, ie '500MB'",
+							},
+						},
 					},
 					{
 						Name:      "invalidate",
@@ -295,15 +690,141 @@ func App() cli.App {
 								Name:  "force",
 								Usage: "do not fail if url cannot be reached",
 							},
+							&cli.StringFlag{
+								Name:  "sha256",
+								Usage: "the expected sha256 digest of the executable",
+							},
+							&cli.StringSliceFlag{
+								Name:  "arg",
+								Usage: "an argument passed to the executable before any given on the command line",
+							},
+							&cli.StringSliceFlag{
+								Name:  "env",
+								Usage: "an environment variable to run the executable with, as KEY=VALUE",
+							},
+							&cli.StringFlag{
+								Name:  "interpreter",
+								Usage: "the command to run the executable with, ie 'python3 -u'",
+							},
+							&cli.StringFlag{
+								Name:  "invalidate-after",
+								Usage: "how long the target is cached for, ie '7d' or '12h30m'",
+							},
+							&cli.StringFlag{
+								Name:  "description",
+								Usage: "a short description of the alias",
+							},
+							&cli.StringSliceFlag{
+								Name:  "tag",
+								Usage: "a keyword to find the alias by with 'fan search'",
+							},
 						},
 					},
 				},
 			},
+			{
+				Name:  "catalog",
+				Usage: "manage subscriptions to alias catalogs",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "list all subscribed catalogs",
+						Before: setup,
+						Action: actionCatalogList,
+					},
+					{
+						Name:   "remove",
+						Usage:  "unsubscribe from a catalog",
+						Before: setup,
+						Action: actionCatalogRemove,
+					},
+					{
+						Name:      "add",
+						Usage:     "subscribe to a catalog, whose aliases are run as '<catalog>/<alias>'",
+						UsageText: "fan catalog add [--force] <name> <url>",
+						Before:    setup,
+						Action:    actionCatalogAdd,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "force",
+								Usage: "do not fail if the catalog cannot be fetched",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "config",
+				Usage: "inspect the effective configuration",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "validate",
+						Usage:     "validate config files against the config schema",
+						UsageText: "fan config validate [path]...",
+						Action:    actionConfigValidate,
+					},
+					{
+						Name:   "show",
+						Usage:  "show the config after merging the system, user, project, and environment layers",
+						Before: setup,
+						Action: actionConfigShow,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "origin",
+								Usage: "show which layer set each value",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:      "search",
+				Usage:     "fuzzy search aliases and scripts by name, tag, description, and url",
+				UsageText: "fan search [--content] [--limit <n>] <term>...",
+				Before:    setup,
+				Action:    actionSearch,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "content",
+						Usage: "also search the content of cached executables",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "show at most this many results",
+					},
+				},
+			},
+			{
+				Name:   "list",
+				Usage:  "list every alias and fanfile script, and where it is defined",
+				Before: setup,
+				Action: actionList,
+			},
+			{
+				Name:      "lock",
+				Usage:     "pin every alias and script to the digest and size of its current content",
+				UsageText: "fan lock [--update <alias[@version]>]...",
+				Before:    setup,
+				Action:    actionLock,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "update",
+						Usage: "only refresh the lockfile entry of the given alias or script",
+					},
+				},
+			},
 			{
 				Name:   "whereis",
 				Usage:  "view the path to the file that is downloaded",
 				Before: setup,
 				Action: actionWhereis,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "verbose",
+						Aliases: []string{"v"},
+						Usage:   "show where the target was fetched from and how it was verified",
+					},
+				},
 			},
 		},
 		Flags: []cli.Flag{
@@ -311,6 +832,11 @@ func App() cli.App {
 				Name:  "config",
 				Value: DefaultConfigPath(),
 			},
+			&cli.StringFlag{
+				Name:  "lockfile",
+				Usage: "the lockfile pinning aliases to the content they are run with",
+				Value: DefaultLockFileName,
+			},
 		},
 	}
 }
@@ -1,18 +1,28 @@
 package cmd_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/cespare/xxhash"
 	"github.com/joshmeranda/fan/cmd"
+	fan "github.com/joshmeranda/fan/pkg"
 	"github.com/joshmeranda/fan/pkg/cache"
 	"github.com/phayes/freeport"
+	"github.com/urfave/cli/v2"
 	"gopkg.in/yaml.v3"
 )
 
@@ -51,7 +61,7 @@ func setup(t *testing.T) (string, string, string) {
 
 	configPath := fmt.Sprintf("%s.config", t.Name())
 	config := cmd.Config{
-		DefaultInvalidateAfter: time.Second * 1,
+		DefaultInvalidateAfter: fan.Duration(time.Second * 1),
 		CacheDir:               fmt.Sprintf("%s.cache", t.Name()),
 	}
 
@@ -68,6 +78,7 @@ func setup(t *testing.T) (string, string, string) {
 		server.Close()
 		os.RemoveAll(config.CacheDir)
 		os.Remove(configPath)
+		os.RemoveAll(cmd.DefaultTrustStoreName)
 	})
 
 	return server.Addr, configPath, config.CacheDir
@@ -147,3 +158,471 @@ func TestMain(t *testing.T) {
 		}
 	})
 }
+
+func TestLocalTarget(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	configPath := filepath.Join(dir, "config")
+	scriptPath := filepath.Join(dir, "script")
+
+	data, err := yaml.Marshal(cmd.Config{
+		DefaultInvalidateAfter: fan.Duration(time.Hour),
+		CacheDir:               cacheDir,
+	})
+	if err != nil {
+		t.Fatalf("could not marshal config: %s", err)
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	if err := os.WriteFile(scriptPath, []byte("#!/usr/bin/env bash\nexit 0"), 0755); err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+
+	hash := xxhash.New()
+	hash.Write([]byte("file://" + scriptPath))
+	cachedScript := filepath.Join(cacheDir, fmt.Sprintf("%d", hash.Sum64()), "script")
+
+	app := cmd.App()
+	app.ExitErrHandler = func(*cli.Context, error) {}
+
+	t.Run("Bare path", func(t *testing.T) {
+		if err := app.Run([]string{"fan", "--config", configPath, "run", scriptPath}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+
+		if !Exists(t, cachedScript) {
+			t.Fatalf("executable does not exist in cache")
+		}
+	})
+
+	t.Run("Modified source", func(t *testing.T) {
+		updated := []byte("#!/usr/bin/env bash\nexit 0 # updated")
+		if err := os.WriteFile(scriptPath, updated, 0755); err != nil {
+			t.Fatalf("could not write script: %s", err)
+		}
+
+		modTime := time.Now().Add(time.Minute)
+		if err := os.Chtimes(scriptPath, modTime, modTime); err != nil {
+			t.Fatalf("could not update script mod time: %s", err)
+		}
+
+		if err := app.Run([]string{"fan", "--config", configPath, "run", "--accept-changes", "file://" + scriptPath}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+
+		cached, err := os.ReadFile(cachedScript)
+		if err != nil {
+			t.Fatalf("could not read cached executable: %s", err)
+		}
+
+		if string(cached) != string(updated) {
+			t.Fatalf("cached executable was not updated")
+		}
+	})
+
+	t.Run("Modified after invalidate", func(t *testing.T) {
+		if err := app.Run([]string{"fan", "--config", configPath, "cache", "invalidate", "--all"}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+
+		if err := os.WriteFile(scriptPath, []byte("#!/usr/bin/env bash\nexit 0 # changed again"), 0755); err != nil {
+			t.Fatalf("could not write script: %s", err)
+		}
+
+		app.Reader = strings.NewReader("")
+
+		err := app.Run([]string{"fan", "--config", configPath, "run", scriptPath})
+		if err == nil {
+			t.Fatalf("expected changed target to be refused once the previous content was invalidated")
+		}
+
+		if !strings.Contains(err.Error(), "--accept-changes") {
+			t.Fatalf("expected error to name --accept-changes: %s", err)
+		}
+
+		if err := app.Run([]string{"fan", "--config", configPath, "run", "--accept-changes", scriptPath}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+
+		if err := app.Run([]string{"fan", "--config", configPath, "run", scriptPath}); err != nil {
+			t.Fatalf("accepted target was refused: %s", err)
+		}
+	})
+}
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	lockPath := filepath.Join(dir, "fan.lock")
+	scriptPath := filepath.Join(dir, "script")
+	script := []byte("#!/usr/bin/env bash\nexit 0")
+
+	data, err := yaml.Marshal(cmd.Config{
+		DefaultInvalidateAfter: fan.Duration(time.Hour),
+		CacheDir:               filepath.Join(dir, "cache"),
+		Aliases: map[string]cmd.Alias{
+			"script": {Url: "file://" + scriptPath},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not marshal config: %s", err)
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	if err := os.WriteFile(scriptPath, script, 0755); err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+
+	app := cmd.App()
+	app.ExitErrHandler = func(*cli.Context, error) {}
+
+	t.Run("Lock", func(t *testing.T) {
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "lock"}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+
+		lock, err := cmd.ReadLockfile(lockPath)
+		if err != nil {
+			t.Fatalf("could not read lockfile: %s", err)
+		}
+
+		locked, found := lock.Aliases["script"]
+		if !found {
+			t.Fatalf("alias was not locked")
+		}
+
+		if locked.Size != int64(len(script)) {
+			t.Fatalf("expected locked size %d but found %d", len(script), locked.Size)
+		}
+	})
+
+	t.Run("Run locked", func(t *testing.T) {
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "script"}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+	})
+
+	t.Run("Run conflicting selection", func(t *testing.T) {
+		other := sha256.Sum256([]byte("#!/usr/bin/env bash\nexit 1"))
+
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "--sha256", hex.EncodeToString(other[:]), "script"}); err == nil {
+			t.Fatalf("expected locked alias to refuse a different sha256")
+		}
+
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "--entrypoint", "bin/script", "script"}); err == nil {
+			t.Fatalf("expected locked alias to refuse a different entrypoint")
+		}
+
+		locked := sha256.Sum256(script)
+
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "--sha256", hex.EncodeToString(locked[:]), "script"}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+	})
+
+	t.Run("Run modified", func(t *testing.T) {
+		if err := os.WriteFile(scriptPath, []byte("#!/usr/bin/env bash\nexit 1"), 0755); err != nil {
+			t.Fatalf("could not write script: %s", err)
+		}
+
+		modTime := time.Now().Add(time.Minute)
+		if err := os.Chtimes(scriptPath, modTime, modTime); err != nil {
+			t.Fatalf("could not update script mod time: %s", err)
+		}
+
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "script"}); err == nil {
+			t.Fatalf("expected locked alias to refuse modified script")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "lock", "--update", "script"}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+
+		if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "script"}); err == nil {
+			t.Fatalf("expected script to exit with failure")
+		} else if !strings.Contains(err.Error(), "exit status 1") {
+			t.Fatalf("app failed with error: %s", err)
+		}
+	})
+}
+
+func TestLockArchive(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	lockPath := filepath.Join(dir, "fan.lock")
+	archivePath := filepath.Join(dir, "tool.tar.gz")
+
+	script := []byte("#!/usr/bin/env bash\nexit 0")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "bin/tool", Mode: 0755, Size: int64(len(script)), Typeflag: tar.TypeReg})
+	tw.Write(script)
+	tw.Close()
+	gz.Close()
+
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("could not write archive: %s", err)
+	}
+
+	data, err := yaml.Marshal(cmd.Config{
+		DefaultInvalidateAfter: fan.Duration(time.Hour),
+		CacheDir:               filepath.Join(dir, "cache"),
+		Aliases: map[string]cmd.Alias{
+			"tool": {Url: "file://" + archivePath},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not marshal config: %s", err)
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	app := cmd.App()
+	app.ExitErrHandler = func(*cli.Context, error) {}
+
+	if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "lock"}); err != nil {
+		t.Fatalf("app failed with error: %s", err)
+	}
+
+	lock, err := cmd.ReadLockfile(lockPath)
+	if err != nil {
+		t.Fatalf("could not read lockfile: %s", err)
+	}
+
+	if locked := lock.Aliases["tool"]; locked.Entrypoint != "bin/tool" || locked.Size != int64(len(script)) {
+		t.Fatalf("expected auto selected entrypoint to be locked: %+v", locked)
+	}
+
+	if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "tool"}); err != nil {
+		t.Fatalf("app failed with error: %s", err)
+	}
+
+	if err := app.Run([]string{"fan", "--config", configPath, "--lockfile", lockPath, "run", "--entrypoint", "bin/tool", "tool"}); err != nil {
+		t.Fatalf("app failed with error: %s", err)
+	}
+}
+
+func TestFanfile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	outPath := filepath.Join(dir, "out")
+
+	data, err := yaml.Marshal(cmd.Config{
+		DefaultInvalidateAfter: fan.Duration(time.Hour),
+		CacheDir:               filepath.Join(dir, "cache"),
+	})
+	if err != nil {
+		t.Fatalf("could not marshal config: %s", err)
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	script := fmt.Sprintf("#!/usr/bin/env bash\necho \"$GREETING $@\" > %s", outPath)
+	if err := os.WriteFile(filepath.Join(dir, "hello.sh"), []byte(script), 0755); err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+
+	fanfile := "scripts:\n  hello:\n    url: ./hello.sh\n    args: [world]\n    env:\n      GREETING: hello\n"
+	if err := os.WriteFile(filepath.Join(dir, cmd.DefaultFanfileName), []byte(fanfile), 0644); err != nil {
+		t.Fatalf("could not write fanfile: %s", err)
+	}
+
+	nested := filepath.Join(dir, "nested", "dir")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatalf("could not create nested dir: %s", err)
+	}
+
+	found, err := cmd.FindFanfile(nested)
+	if err != nil {
+		t.Fatalf("could not find fanfile: %s", err)
+	}
+
+	if expected := filepath.Join(dir, cmd.DefaultFanfileName); found != expected {
+		t.Fatalf("expected fanfile '%s' but found '%s'", expected, found)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("could not get working directory: %s", err)
+	}
+
+	if err := os.Chdir(nested); err != nil {
+		t.Fatalf("could not change working directory: %s", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	app := cmd.App()
+
+	if err := app.Run([]string{"fan", "--config", configPath, "run", "hello", "again"}); err != nil {
+		t.Fatalf("app failed with error: %s", err)
+	}
+
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("could not read script output: %s", err)
+	}
+
+	if string(out) != "hello world again\n" {
+		t.Fatalf("unexpected script output: %q", out)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	outPath := filepath.Join(dir, "out")
+
+	data, err := yaml.Marshal(cmd.Config{
+		DefaultInvalidateAfter: fan.Duration(time.Hour),
+		CacheDir:               filepath.Join(dir, "cache"),
+	})
+	if err != nil {
+		t.Fatalf("could not marshal config: %s", err)
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "v1"), 0755); err != nil {
+		t.Fatalf("could not create script dir: %s", err)
+	}
+
+	script := fmt.Sprintf("#!/usr/bin/env bash\necho \"$@\" > %s", outPath)
+	if err := os.WriteFile(filepath.Join(dir, "v1", "hello.sh"), []byte(script), 0755); err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+
+	catalog := "aliases:\n  hello:\n    url: ./{{.Version}}/hello.sh\n    args: [hello]\n"
+	if err := os.WriteFile(filepath.Join(dir, "catalog.yaml"), []byte(catalog), 0644); err != nil {
+		t.Fatalf("could not write catalog: %s", err)
+	}
+
+	app := cmd.App()
+
+	if err := app.Run([]string{"fan", "--config", configPath, "catalog", "add", "team", filepath.Join(dir, "catalog.yaml")}); err != nil {
+		t.Fatalf("app failed with error: %s", err)
+	}
+
+	if err := app.Run([]string{"fan", "--config", configPath, "run", "--accept-changes", "team/hello@v1", "world"}); err != nil {
+		t.Fatalf("app failed with error: %s", err)
+	}
+
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("could not read script output: %s", err)
+	}
+
+	if string(out) != "hello world\n" {
+		t.Fatalf("unexpected script output: %q", out)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+
+	scripts := map[string]string{
+		"kubectl": "#!/usr/bin/env bash\n# deploys pods\nexit 0",
+		"ktail":   "#!/usr/bin/env bash\nexit 0",
+		"helm":    "#!/usr/bin/env bash\nexit 0",
+	}
+
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name+".sh"), []byte(script), 0755); err != nil {
+			t.Fatalf("could not write script: %s", err)
+		}
+	}
+
+	// local files are always revalidated rather than expiring, so the expiring alias is served over http
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	data, err := yaml.Marshal(cmd.Config{
+		DefaultInvalidateAfter: fan.Duration(time.Hour),
+		CacheDir:               filepath.Join(dir, "cache"),
+		Aliases: map[string]cmd.Alias{
+			"kubectl": {Url: filepath.Join(dir, "kubectl.sh"), Description: "kubernetes command line tool", Tags: []string{"k8s"}},
+			"ktail":   {Url: server.URL + "/ktail.sh", InvalidateAfter: fan.Duration(time.Millisecond)},
+			"helm":    {Url: filepath.Join(dir, "helm.sh")},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not marshal config: %s", err)
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatalf("could not write config file: %s", err)
+	}
+
+	app := cmd.App()
+
+	for _, name := range []string{"kubectl", "ktail"} {
+		if err := app.Run([]string{"fan", "--config", configPath, "run", name}); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+	}
+
+	// let the ktail entry expire
+	time.Sleep(time.Millisecond * 10)
+
+	search := func(args ...string) string {
+		var out bytes.Buffer
+		app.Writer = &out
+
+		if err := app.Run(append([]string{"fan", "--config", configPath, "search"}, args...)); err != nil {
+			t.Fatalf("app failed with error: %s", err)
+		}
+
+		return out.String()
+	}
+
+	t.Run("Ranked", func(t *testing.T) {
+		expected := fmt.Sprintf("ktail    expired  %s/ktail.sh\nkubectl  cached   file://%s\n         kubernetes command line tool\n",
+			server.URL, filepath.Join(dir, "kubectl.sh"))
+		if out := search("kt"); out != expected {
+			t.Fatalf("unexpected search output: %q", out)
+		}
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		// columns are only as wide as the results shown
+		expected := fmt.Sprintf("ktail  expired  %s/ktail.sh\n", server.URL)
+		if out := search("--limit", "1", "kt"); out != expected {
+			t.Fatalf("unexpected search output: %q", out)
+		}
+	})
+
+	t.Run("NotCached", func(t *testing.T) {
+		expected := fmt.Sprintf("helm  not cached  file://%s\n", filepath.Join(dir, "helm.sh"))
+		if out := search("helm"); out != expected {
+			t.Fatalf("unexpected search output: %q", out)
+		}
+	})
+
+	t.Run("Content", func(t *testing.T) {
+		if out := search("deploys", "pods"); out != "" {
+			t.Fatalf("unexpected search output: %q", out)
+		}
+
+		expected := fmt.Sprintf("kubectl  cached  file://%s\n         kubernetes command line tool\n         2: # deploys pods\n",
+			filepath.Join(dir, "kubectl.sh"))
+		if out := search("--content", "deploys", "pods"); out != expected {
+			t.Fatalf("unexpected search output: %q", out)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog is a published set of aliases which can be subscribed to, written as YAML or JSON.
+type Catalog struct {
+	Aliases map[string]Alias `yaml:"aliases"`
+}
+
+// catalogs are the catalogs which have already been loaded, by name.
+var catalogs map[string]Catalog
+
+// ReadCatalog reads the catalog at path which was fetched from base. Alias urls which are relative to the catalog ('./'
+// or '../') are resolved against base when they are run.
+func ReadCatalog(path string, base string) (Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Catalog{}, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var catalog Catalog
+	if err := yaml.Unmarshal(data, &catalog); err != nil {
+		return Catalog{}, fmt.Errorf("failed to parse catalog '%s': %w", base, err)
+	}
+
+	baseUrl, err := url.Parse(base)
+	if err != nil {
+		return Catalog{}, fmt.Errorf("failed to parse catalog url: %w", err)
+	}
+
+	for name, alias := range catalog.Aliases {
+		if alias.Url == "" {
+			return Catalog{}, fmt.Errorf("alias '%s' in catalog '%s' has no url", name, base)
+		}
+
+		alias.base = baseUrl
+		catalog.Aliases[name] = alias
+	}
+
+	return catalog, nil
+}
+
+// named returns the alias called name in the catalog.
+func (c Catalog) named(name string) (Alias, bool) {
+	alias, found := c.Aliases[name]
+	return alias, found
+}
+
+// catalogRef splits a '<catalog>/<alias>' reference into the name of a subscribed catalog and the rest of the
+// reference.
+func catalogRef(ref string) (string, string, bool) {
+	name, rest, found := strings.Cut(ref, "/")
+	if !found || rest == "" {
+		return "", "", false
+	}
+
+	if _, found := config.Catalogs[name]; !found {
+		return "", "", false
+	}
+
+	return name, rest, true
+}
+
+// loadCatalog returns the subscribed catalog called name. Catalogs are cached like any other target, so they are only
+// fetched again once they expire.
+func loadCatalog(name string) (Catalog, error) {
+	if catalog, found := catalogs[name]; found {
+		return catalog, nil
+	}
+
+	raw, found := config.Catalogs[name]
+	if !found {
+		return Catalog{}, fmt.Errorf("no such catalog '%s'", name)
+	}
+
+	target := fan.Target{
+		Url:             fan.NormalizeUrl(raw),
+		InvalidateAfter: time.Duration(config.DefaultInvalidateAfter),
+	}
+
+	target, path, err := cache.FetchTarget(fanCache, fan.DefaultRegistry, target, nil)
+	if err != nil {
+		return Catalog{}, fmt.Errorf("failed to fetch catalog '%s': %w", name, err)
+	}
+
+	if target.NoStore {
+		defer fanCache.InvalidateUrl(target.Url)
+	}
+
+	catalog, err := ReadCatalog(path, target.Url)
+	if err != nil {
+		return Catalog{}, err
+	}
+
+	if catalogs == nil {
+		catalogs = make(map[string]Catalog)
+	}
+	catalogs[name] = catalog
+
+	return catalog, nil
+}
+
+func actionCatalogAdd(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return cli.Exit("expected catalog name and url", 1)
+	}
+
+	name, raw := ctx.Args().First(), ctx.Args().Get(1)
+
+	if name == "" || strings.Contains(name, "/") {
+		return cli.Exit(fmt.Sprintf("invalid catalog name '%s', names may not contain '/'", name), 1)
+	}
+
+	config.Catalogs[name] = raw
+
+	if !ctx.Bool("force") {
+		if _, err := loadCatalog(name); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+
+	var node yaml.Node
+	if err := node.Encode(raw); err != nil {
+		return cli.Exit("failed to marshal catalog: "+err.Error(), 1)
+	}
+
+	err := updateConfigFile(ctx.String("config"), func(root *yaml.Node) {
+		setMappingValue(ensureMapping(root, "catalogs"), name, &node)
+	})
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	return nil
+}
+
+func actionCatalogList(ctx *cli.Context) error {
+	subscribed := make([]string, 0, len(config.Catalogs))
+	maxNameLen := 0
+
+	for name := range config.Catalogs {
+		subscribed = append(subscribed, name)
+
+		if l := len(name); l > maxNameLen {
+			maxNameLen = l
+		}
+	}
+
+	sort.Strings(subscribed)
+
+	for _, name := range subscribed {
+		fmt.Printf("%-*s  %s  (%s)\n", maxNameLen, name, config.Catalogs[name], configLayers.origin("catalogs."+name).Origin())
+	}
+
+	return nil
+}
+
+func actionCatalogRemove(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return cli.Exit("expected at least 1 catalog", 1)
+	}
+
+	for _, name := range ctx.Args().Slice() {
+		if origin := configLayers.origin("catalogs." + name); origin.Name != LayerUser {
+			log.Warn("catalog is not defined in the user config and will not be removed", "catalog", name, "origin", origin.Origin())
+		}
+
+		delete(config.Catalogs, name)
+	}
+
+	err := updateConfigFile(ctx.String("config"), func(root *yaml.Node) {
+		mapping := mappingValue(root, "catalogs")
+		if mapping == nil || mapping.Kind != yaml.MappingNode {
+			return
+		}
+
+		for _, name := range ctx.Args().Slice() {
+			deleteMappingKey(mapping, name)
+		}
+	})
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	return nil
+}
@@ -1,19 +1,88 @@
 package cmd
 
 import (
-	"time"
+	fan "github.com/joshmeranda/fan/pkg"
 )
 
 type Config struct {
-	DefaultInvalidateAfter time.Duration
+	DefaultInvalidateAfter fan.Duration
 	CacheDir               string
-	Aliases                map[string]string
+
+	// MaxSize is the size the cache is kept under by evicting the least recently used targets, if zero the cache is
+	// unbounded.
+	MaxSize fan.ByteSize
+
+	Aliases map[string]Alias
+
+	// Catalogs are the urls of the alias catalogs subscribed to by name, their aliases are run as '<catalog>/<alias>'.
+	Catalogs map[string]string
+
+	// SymlinkLocalTargets will link to local targets from the cache rather than copying them.
+	SymlinkLocalTargets bool
+
+	// S3 configures how s3:// targets are fetched.
+	S3 S3Config
+
+	// OCI configures how oci:// targets are fetched.
+	OCI OCIConfig
+
+	// SSH configures how sftp:// and scp style targets are fetched.
+	SSH SSHConfig
+
+	// Trust configures the verification of detached target signatures.
+	Trust TrustConfig
+}
+
+type S3Config struct {
+	// Region is the region of the buckets targets are fetched from.
+	Region string
+
+	// Endpoint is the base url of an S3 compatible service to use instead of AWS.
+	Endpoint string
+
+	// Profile is the profile to load from the AWS shared credentials file.
+	Profile string
+}
+
+type OCIConfig struct {
+	// Username and Password are used to authenticate with registries, if empty registries are accessed anonymously.
+	Username string
+	Password string
+
+	// PlainHTTP will connect to registries over http rather than https.
+	PlainHTTP bool
+}
+
+type SSHConfig struct {
+	// User is the user to connect as when a target does not specify one.
+	User string
+
+	// IdentityFiles are the private keys to authenticate with in addition to the ssh agent.
+	IdentityFiles []string
+
+	// KnownHostsFiles are the files used to verify host keys.
+	KnownHostsFiles []string
+}
+
+type TrustConfig struct {
+	// Keys are the public keys trusted to sign targets, each either a minisign public key, an ssh authorized key, or a
+	// path to a file containing one.
+	Keys []string
+
+	// Keyring is the path to an ASCII-armored OpenPGP keyring whose keys are trusted to sign targets.
+	Keyring string
+
+	// RequireSignature will refuse to run targets which are not signed by a trusted key.
+	RequireSignature bool
+
+	// SSHNamespace is the namespace ssh signatures must be made for, if empty "file" is used.
+	SSHNamespace string
 }
 
 func DefaultConfig() Config {
 	return Config{
-		DefaultInvalidateAfter: time.Hour * 24 * 7, // ~1 week
+		DefaultInvalidateAfter: fan.Duration(fan.Week),
 		CacheDir:               DefaultCachePath(),
-		Aliases:                make(map[string]string, 0),
+		Aliases:                make(map[string]Alias, 0),
 	}
 }
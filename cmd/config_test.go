@@ -0,0 +1,194 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/joshmeranda/fan/cmd"
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	systemPath := filepath.Join(dir, "system.yaml")
+	userPath := filepath.Join(dir, "user.yaml")
+	projectPath := filepath.Join(dir, "project.yaml")
+
+	previous := cmd.SystemConfigPath
+	cmd.SystemConfigPath = systemPath
+	t.Cleanup(func() { cmd.SystemConfigPath = previous })
+
+	assert.NoError(t, os.WriteFile(systemPath, []byte("cachedir: /var/cache/fan\naliases:\n  a: https://example.com/system\n  b:\n    url: https://example.com/system\n    sha256: \"0000000000000000000000000000000000000000000000000000000000000000\"\ns3:\n  region: us-east-1\n"), 0o644))
+	assert.NoError(t, os.WriteFile(userPath, []byte("aliases:\n  b:\n    url: https://example.com/user\n    args: [--user]\ns3:\n  endpoint: http://localhost:9000\n"), 0o644))
+	assert.NoError(t, os.WriteFile(projectPath, []byte("s3:\n  region: eu-west-1\n"), 0o644))
+
+	environ := []string{
+		"FAN_CACHE_DIR=/tmp/fan",
+		"FAN_SYMLINK_LOCAL_TARGETS=true",
+		"FAN_SSH_IDENTITY_FILES=a" + string(os.PathListSeparator) + "b",
+		"FAN_OCI_PLAIN_HTTP=true",
+	}
+
+	layered, err := cmd.LoadConfig(userPath, projectPath, environ)
+	assert.NoError(t, err)
+
+	config := layered.Config
+	assert.Equal(t, cmd.DefaultConfig().DefaultInvalidateAfter, config.DefaultInvalidateAfter)
+	assert.Equal(t, "/tmp/fan", config.CacheDir)
+	assert.True(t, config.SymlinkLocalTargets)
+	assert.True(t, config.OCI.PlainHTTP)
+	assert.Equal(t, []string{"a", "b"}, config.SSH.IdentityFiles)
+	assert.Equal(t, "eu-west-1", config.S3.Region)
+	assert.Equal(t, "http://localhost:9000", config.S3.Endpoint)
+	assert.Equal(t, map[string]cmd.Alias{
+		"a": {Url: "https://example.com/system"},
+		"b": {Url: "https://example.com/user", Args: []string{"--user"}},
+	}, config.Aliases)
+
+	assert.Equal(t, cmd.LayerEnv, layered.Origins["cachedir"].Name)
+	assert.Equal(t, cmd.LayerSystem, layered.Origins["aliases.a"].Name)
+	assert.Equal(t, cmd.LayerUser, layered.Origins["aliases.b"].Name)
+	assert.Equal(t, cmd.LayerProject, layered.Origins["s3.region"].Name)
+
+	var out strings.Builder
+	assert.NoError(t, layered.WriteOrigins(&out))
+	assert.Contains(t, out.String(), "s3.region: eu-west-1  # project ("+projectPath+")\n")
+	assert.Contains(t, out.String(), "defaultinvalidateafter: 7d  # default\n")
+}
+
+func TestLoadConfigProjectRestricted(t *testing.T) {
+	type testCase struct {
+		Name    string
+		Project string
+		Err     string
+	}
+
+	cases := []testCase{
+		{Name: "Allowed", Project: "s3:\n  region: eu-west-1\naliases:\n  a: https://example.com/a\n"},
+		{Name: "RequireSignature", Project: "trust:\n  requiresignature: false\n", Err: "'trust'"},
+		{Name: "Keys", Project: "trust:\n  keys: [untrusted]\n", Err: "'trust'"},
+		{Name: "CacheDir", Project: "cachedir: /tmp/fan\n", Err: "'cachedir'"},
+		{Name: "Credentials", Project: "s3:\n  profile: other\noci:\n  password: secret\n", Err: "'s3.profile', 'oci'"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			userPath := filepath.Join(dir, "user.yaml")
+			projectPath := filepath.Join(dir, ".fan.config")
+
+			previous := cmd.SystemConfigPath
+			cmd.SystemConfigPath = filepath.Join(dir, "system.yaml")
+			t.Cleanup(func() { cmd.SystemConfigPath = previous })
+
+			assert.NoError(t, os.WriteFile(userPath, []byte("cachedir: /var/cache/fan\ntrust:\n  requiresignature: true\n"), 0o644))
+			assert.NoError(t, os.WriteFile(projectPath, []byte(c.Project), 0o644))
+
+			layered, err := cmd.LoadConfig(userPath, projectPath, nil)
+			if c.Err != "" {
+				assert.ErrorContains(t, err, c.Err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "/var/cache/fan", layered.Config.CacheDir)
+			assert.True(t, layered.Config.Trust.RequireSignature)
+
+			// the same keys may still be set by the environment
+			layered, err = cmd.LoadConfig(userPath, projectPath, []string{"FAN_TRUST_REQUIRE_SIGNATURE=false"})
+			assert.NoError(t, err)
+			assert.False(t, layered.Config.Trust.RequireSignature)
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	var node yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte("defaultinvalidateafter: 12h30m\ncachedir: [a]\ns3:\n  bogus: x\n"), &node))
+
+	err := cmd.ValidateConfigNode(node.Content[0])
+
+	var validationErrors cmd.ValidationErrors
+	assert.ErrorAs(t, err, &validationErrors)
+	assert.Equal(t, cmd.ValidationErrors{
+		{Path: "cachedir", Line: 2, Column: 11, Message: "expected string but found array"},
+		{Path: "s3.bogus", Line: 4, Column: 3, Message: "unknown key, expected one of: endpoint, profile, region"},
+	}, validationErrors)
+
+	data, err := yaml.Marshal(cmd.DefaultConfig())
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "defaultinvalidateafter: 7d\n")
+
+	assert.NoError(t, yaml.Unmarshal(data, &node))
+	assert.NoError(t, cmd.ValidateConfigNode(node.Content[0]))
+
+	// scalars are decoded into strings as their text, so they are as valid as strings
+	data = []byte("aliases:\n  serve:\n    url: https://example.com/serve\n    args: [--port, 8080, --verbose, true]\n    env:\n      PORT: 8080\n      DEBUG: false\n")
+	assert.NoError(t, yaml.Unmarshal(data, &node))
+	assert.NoError(t, cmd.ValidateConfigNode(node.Content[0]))
+
+	var config cmd.Config
+	assert.NoError(t, yaml.Unmarshal(data, &config))
+	assert.Equal(t, []string{"--port", "8080", "--verbose", "true"}, config.Aliases["serve"].Args)
+	assert.Equal(t, map[string]string{"PORT": "8080", "DEBUG": "false"}, config.Aliases["serve"].Env)
+
+	assert.NoError(t, yaml.Unmarshal([]byte("aliases:\n  serve:\n    url: https://example.com/serve\n    args: [[a]]\n"), &node))
+	assert.Equal(t, cmd.ValidationErrors{
+		{Path: "aliases.serve.args[0]", Line: 4, Column: 12, Message: "expected string or number or boolean but found array"},
+	}, cmd.ValidateConfigNode(node.Content[0]))
+}
+
+func TestAlias(t *testing.T) {
+	var aliases map[string]cmd.Alias
+	assert.NoError(t, yaml.Unmarshal([]byte("a: https://example.com/a\nb:\n  url: https://example.com/b\n  args: [-v]\n  invalidateafter: 1d\n"), &aliases))
+	assert.Equal(t, map[string]cmd.Alias{
+		"a": {Url: "https://example.com/a"},
+		"b": {Url: "https://example.com/b", Args: []string{"-v"}, InvalidateAfter: fan.Duration(fan.Day)},
+	}, aliases)
+
+	data, err := yaml.Marshal(aliases)
+	assert.NoError(t, err)
+	assert.Equal(t, "a: https://example.com/a\nb:\n    url: https://example.com/b\n    args:\n        - -v\n    invalidateafter: 1d\n", string(data))
+
+	var node yaml.Node
+	assert.NoError(t, yaml.Unmarshal([]byte("aliases:\n  a:\n    args: [-v]\n"), &node))
+	assert.Equal(t, cmd.ValidationErrors{
+		{Path: "aliases.a", Line: 3, Column: 5, Message: "missing required key 'url'"},
+	}, cmd.ValidateConfigNode(node.Content[0]))
+}
+
+func TestAliasTemplate(t *testing.T) {
+	alias := cmd.Alias{Url: "https://example.com/{{.Version}}/{{.OS}}/{{.Arch}}/tool"}
+	assert.True(t, alias.IsTemplate())
+
+	expanded, err := alias.Expand("1.29")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/1.29/"+runtime.GOOS+"/"+runtime.GOARCH+"/tool", expanded.Url)
+
+	_, err = alias.Expand("")
+	assert.ErrorIs(t, err, cmd.ErrVersionRequired)
+
+	_, err = cmd.Alias{Url: "https://example.com/{{.OS}}/tool"}.Expand("1.29")
+	assert.ErrorIs(t, err, cmd.ErrVersionNotAccepted)
+
+	_, err = cmd.Alias{Url: "https://example.com/{{.Bogus}}/tool"}.Expand("1.29")
+	assert.Error(t, err)
+}
+
+func TestAliasScore(t *testing.T) {
+	kubectl := cmd.Alias{Url: "https://example.com/kubectl", Description: "kubernetes command line tool", Tags: []string{"k8s"}}
+	ktail := cmd.Alias{Url: "https://example.com/ktail"}
+
+	assert.Greater(t, kubectl.Score("kubectl", "kubectl"), kubectl.Score("kubectl", "kube"))
+	assert.Greater(t, kubectl.Score("kubectl", "kube"), kubectl.Score("kubectl", "kctl"))
+	assert.Greater(t, kubectl.Score("kubectl", "kctl"), 0)
+	assert.Greater(t, kubectl.Score("kubectl", "k8s"), ktail.Score("ktail", "k8s"))
+	assert.Greater(t, kubectl.Score("kubectl", "command"), 0)
+	assert.Zero(t, ktail.Score("ktail", "kubectl"))
+}
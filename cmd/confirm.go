@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/joshmeranda/fan/pkg/trust"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// maxDiffSize is the largest executable which is diffed, larger executables only have their digests shown.
+const maxDiffSize = 1 << 20
+
+var errChangeDeclined = errors.New("declined to run changed target")
+
+// diffExecutables returns a unified diff of two executables, or an empty string if either is binary or too large to
+// reasonably show.
+func diffExecutables(previous string, previousLabel string, current string, currentLabel string) (string, error) {
+	a, err := os.ReadFile(previous)
+	if err != nil {
+		return "", fmt.Errorf("failed to read previous executable: %w", err)
+	}
+
+	b, err := os.ReadFile(current)
+	if err != nil {
+		return "", fmt.Errorf("failed to read new executable: %w", err)
+	}
+
+	if len(a) > maxDiffSize || len(b) > maxDiffSize || bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		return "", nil
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(a)),
+		B:        difflib.SplitLines(string(b)),
+		FromFile: previousLabel,
+		ToFile:   currentLabel,
+		Context:  3,
+	})
+}
+
+// promptConfirmChange returns a cache.ConfirmChange which shows how a target changed on out and asks for confirmation
+// on in. If previousExecutable is empty, only the digests of the executables are shown.
+func promptConfirmChange(in io.Reader, out io.Writer) cache.ConfirmChange {
+	return func(previous fan.Target, previousExecutable string, current fan.Target, executable string) error {
+		previousLabel := "sha256:" + previous.Sha256
+		currentLabel := "sha256:" + current.Sha256
+
+		fmt.Fprintf(out, "'%s' has changed since it was last run\n", current.Url)
+
+		var diff string
+		if previousExecutable != "" {
+			var err error
+			if diff, err = diffExecutables(previousExecutable, previousLabel, executable, currentLabel); err != nil {
+				return err
+			}
+		}
+
+		if diff == "" {
+			fmt.Fprintf(out, "--- %s\n+++ %s\n", previousLabel, currentLabel)
+		} else {
+			fmt.Fprint(out, diff)
+		}
+
+		fmt.Fprint(out, "run the changed target? [y/N] ")
+
+		answer, err := bufio.NewReader(in).ReadString('\n')
+		if errors.Is(err, io.EOF) && answer == "" {
+			fmt.Fprintln(out)
+			return fmt.Errorf("%w: no answer could be read, run with --accept-changes to accept the change", errChangeDeclined)
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read confirmation: %w", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return nil
+		default:
+			return errChangeDeclined
+		}
+	}
+}
+
+// changeConfirmer confirms changes to the executable of the target at url against the digests accepted for it in a
+// trust store, so that changes are noticed even when the previous content is no longer cached.
+type changeConfirmer struct {
+	store *trust.Store
+
+	// url is the requested url of the target, before it is resolved to the content it refers to.
+	url string
+
+	// accept records changes without prompting.
+	accept bool
+
+	prompt cache.ConfirmChange
+}
+
+// confirm is a cache.ConfirmChange which accepts content which was already accepted for the target, and otherwise
+// prompts for the change before recording it as accepted.
+func (c *changeConfirmer) confirm(previous fan.Target, previousExecutable string, current fan.Target, executable string) error {
+	record, found, err := c.store.Get(c.url)
+	if err != nil {
+		return err
+	}
+
+	if found && record.Sha256 == current.Sha256 {
+		return nil
+	}
+
+	if !c.accept {
+		if err := c.prompt(previous, previousExecutable, current, executable); err != nil {
+			return err
+		}
+	}
+
+	return c.store.Accept(c.url, current.Sha256)
+}
+
+// check compares the executable of a fetched target against the digest accepted for it, recording the digest if the
+// target was never run before. Targets pinned to a digest are not checked, since their url already states which
+// content is expected.
+func (c *changeConfirmer) check(target fan.Target, executable string) error {
+	if target.Sha256 == "" {
+		return nil
+	}
+
+	if u, err := url.Parse(c.url); err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	} else if pinned, _ := fan.Sha256FromUrl(u); pinned != "" {
+		return nil
+	}
+
+	record, found, err := c.store.Get(c.url)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return c.store.Accept(c.url, target.Sha256)
+	}
+
+	if record.Sha256 == target.Sha256 {
+		return nil
+	}
+
+	return c.confirm(fan.Target{Url: target.Url, Sha256: record.Sha256}, "", target, executable)
+}
@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFanfileName is the name of the project file looked for in the working directory and its parents.
+const DefaultFanfileName = "fanfile.yaml"
+
+// Fanfile declares the scripts of a project, layered over the aliases of the user config. Scripts are written the
+// same way as aliases.
+type Fanfile struct {
+	Scripts map[string]Alias `yaml:"scripts"`
+
+	// Path is the location the fanfile was read from.
+	Path string `yaml:"-"`
+}
+
+// findUp returns the path of the nearest file called name in dir or its parents, or an empty string if there is none.
+func findUp(dir string, name string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve directory: %w", err)
+	}
+
+	for {
+		path := filepath.Join(dir, name)
+
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to check for '%s': %w", path, err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+
+		dir = parent
+	}
+}
+
+// FindFanfile returns the path of the nearest fanfile in dir or its parents, or an empty string if there is none.
+func FindFanfile(dir string) (string, error) {
+	return findUp(dir, DefaultFanfileName)
+}
+
+// ReadFanfile reads the fanfile at path. Script urls which are paths relative to the fanfile ('./' or '../') are
+// resolved against its directory.
+func ReadFanfile(path string) (Fanfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Fanfile{}, fmt.Errorf("failed to read fanfile: %w", err)
+	}
+
+	var fanfile Fanfile
+	if err := yaml.Unmarshal(data, &fanfile); err != nil {
+		return Fanfile{}, fmt.Errorf("failed to parse fanfile '%s': %w", path, err)
+	}
+
+	fanfile.Path = path
+
+	for name, script := range fanfile.Scripts {
+		if script.Url == "" {
+			return Fanfile{}, fmt.Errorf("script '%s' in '%s' has no url", name, path)
+		}
+
+		if strings.HasPrefix(script.Url, "./") || strings.HasPrefix(script.Url, "../") {
+			script.Url = filepath.Join(filepath.Dir(path), filepath.FromSlash(script.Url))
+		}
+
+		if script.IsTemplate() {
+			_, err = script.urlTemplate()
+		} else {
+			_, err = script.ResolvedUrl()
+		}
+
+		if err != nil {
+			return Fanfile{}, fmt.Errorf("invalid script '%s' in '%s': %w", name, path, err)
+		}
+
+		fanfile.Scripts[name] = script
+	}
+
+	return fanfile, nil
+}
+
+// loadFanfile finds and reads the fanfile for the working directory, returning an empty fanfile if there is none.
+func loadFanfile() (Fanfile, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return Fanfile{}, fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	path, err := FindFanfile(wd)
+	if err != nil || path == "" {
+		return Fanfile{}, err
+	}
+
+	return ReadFanfile(path)
+}
+
+// names returns the sorted names of every alias and script.
+func names() []string {
+	var names []string
+
+	for alias := range config.Aliases {
+		if _, found := fanfile.Scripts[alias]; !found {
+			names = append(names, alias)
+		}
+	}
+
+	for name := range fanfile.Scripts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// listing is a script or alias, and where it is defined.
+type listing struct {
+	name   string
+	alias  Alias
+	origin string
+}
+
+// listings returns every script and alias, followed by the aliases of each subscribed catalog as '<catalog>/<alias>'.
+// Catalogs which cannot be loaded are skipped with a warning.
+func listings() []listing {
+	var all []listing
+
+	for _, name := range names() {
+		origin := configLayers.origin("aliases." + name).Origin()
+		if _, found := fanfile.Scripts[name]; found {
+			origin = fanfile.Path
+		}
+
+		alias, _ := named(name)
+		all = append(all, listing{name: name, alias: alias, origin: origin})
+	}
+
+	subscribed := make([]string, 0, len(config.Catalogs))
+	for name := range config.Catalogs {
+		subscribed = append(subscribed, name)
+	}
+	sort.Strings(subscribed)
+
+	for _, catalogName := range subscribed {
+		catalog, err := loadCatalog(catalogName)
+		if err != nil {
+			log.Warn("skipping catalog", "catalog", catalogName, "err", err)
+			continue
+		}
+
+		aliases := make([]string, 0, len(catalog.Aliases))
+		for name := range catalog.Aliases {
+			aliases = append(aliases, name)
+		}
+		sort.Strings(aliases)
+
+		for _, name := range aliases {
+			all = append(all, listing{
+				name:   catalogName + "/" + name,
+				alias:  catalog.Aliases[name],
+				origin: config.Catalogs[catalogName],
+			})
+		}
+	}
+
+	return all
+}
+
+// displayUrl returns the url which is shown for alias. Templates are shown as is since they have no url until they
+// are given a version.
+func displayUrl(alias Alias) (string, error) {
+	if alias.IsTemplate() {
+		return alias.Url, nil
+	}
+
+	return alias.ResolvedUrl()
+}
+
+func actionList(ctx *cli.Context) error {
+	all := listings()
+
+	maxNameLen := 0
+	for _, l := range all {
+		if n := len(l.name); n > maxNameLen {
+			maxNameLen = n
+		}
+	}
+
+	for _, l := range all {
+		url, err := displayUrl(l.alias)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("invalid alias '%s': %s", l.name, err), 1)
+		}
+
+		fmt.Printf("%-*s  %s  (%s)\n", maxNameLen, l.name, url, l.origin)
+	}
+
+	return nil
+}
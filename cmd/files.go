@@ -11,6 +11,10 @@ const (
 
 	// ConfigFileName is the name of the configuration file.
 	DefaultConfigFileName = "fan.config"
+
+	// DefaultTrustStoreName is the name of the directory next to the configuration file where the digests of accepted
+	// targets are stored.
+	DefaultTrustStoreName = "fan.trusted"
 )
 
 func DefaultConfigPath() string {
@@ -0,0 +1,565 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SystemConfigPath is the system wide config, which is overridden by every other layer.
+var SystemConfigPath = "/etc/fan/config.yaml"
+
+// DefaultProjectConfigFileName is the name of the project config looked for in the working directory and its parents.
+const DefaultProjectConfigFileName = ".fan.config"
+
+// EnvPrefix is the prefix of environment variables which override config values, ie FAN_CACHE_DIR.
+const EnvPrefix = "FAN_"
+
+// Config layers, in order of increasing precedence.
+const (
+	LayerDefault = "default"
+	LayerSystem  = "system"
+	LayerUser    = "user"
+	LayerProject = "project"
+	LayerEnv     = "env"
+)
+
+// ConfigLayer is a partial config from a single source.
+type ConfigLayer struct {
+	Name string
+
+	// Path is the file the layer was read from, or empty if it was not read from a file.
+	Path string
+
+	node *yaml.Node
+}
+
+func (l ConfigLayer) Origin() string {
+	if l.Path == "" {
+		return l.Name
+	}
+
+	return fmt.Sprintf("%s (%s)", l.Name, l.Path)
+}
+
+// LayeredConfig is the effective config after merging each layer, and which layer each value came from.
+type LayeredConfig struct {
+	Config Config
+
+	// Origins maps the dotted key of each value to the layer which set it.
+	Origins map[string]ConfigLayer
+
+	node *yaml.Node
+}
+
+// readConfigNode reads the mapping at the root of the yaml file at path, a missing or empty file is returned as nil.
+func readConfigNode(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, configSourceError(path, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if err := ValidateConfigNode(root); err != nil {
+		return nil, configSourceError(path, err)
+	}
+
+	return root, nil
+}
+
+// configSourceError prefixes each error found in a config with the source it was read from.
+func configSourceError(source string, err error) error {
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	messages := make([]string, 0, len(validationErrors))
+	for _, validationError := range validationErrors {
+		if validationError.Line == 0 {
+			messages = append(messages, fmt.Sprintf("%s: %s", source, validationError))
+		} else {
+			messages = append(messages, fmt.Sprintf("%s:%s", source, validationError))
+		}
+	}
+
+	return fmt.Errorf("invalid config:\n%s", strings.Join(messages, "\n"))
+}
+
+// findProjectConfig returns the path of the nearest project config to the working directory, or an empty string if
+// there is none.
+func findProjectConfig() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	path, err := findUp(wd, DefaultProjectConfigFileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to find project config: %w", err)
+	}
+
+	return path, nil
+}
+
+// ReadConfigLayer reads the layer at path, returning false if there is no such file.
+func ReadConfigLayer(name string, path string) (ConfigLayer, bool, error) {
+	node, err := readConfigNode(path)
+	if err != nil || node == nil {
+		return ConfigLayer{}, false, err
+	}
+
+	return ConfigLayer{
+		Name: name,
+		Path: path,
+		node: node,
+	}, true, nil
+}
+
+func defaultLayer() (ConfigLayer, error) {
+	var node yaml.Node
+	if err := node.Encode(DefaultConfig()); err != nil {
+		return ConfigLayer{}, fmt.Errorf("failed to encode default config: %w", err)
+	}
+
+	return ConfigLayer{
+		Name: LayerDefault,
+		node: &node,
+	}, nil
+}
+
+// envName converts a Go field name to the upper snake case used by environment variables, ie PlainHTTP to PLAIN_HTTP.
+func envName(field string) string {
+	runes := []rune(field)
+
+	var b strings.Builder
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if !unicode.IsUpper(prev) || nextIsLower {
+				b.WriteRune('_')
+			}
+		}
+
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// envVar is a config value which may be set by an environment variable.
+type envVar struct {
+	name string
+	keys []string
+	kind reflect.Kind
+}
+
+// envVars returns the environment variables for each value of t. Maps cannot be set from the environment.
+func envVars(t reflect.Type, prefix string, keys []string) []envVar {
+	var vars []envVar
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + envName(field.Name)
+		fieldKeys := append(append([]string{}, keys...), strings.ToLower(field.Name))
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			vars = append(vars, envVars(field.Type, name+"_", fieldKeys)...)
+		case reflect.Map:
+		default:
+			vars = append(vars, envVar{
+				name: name,
+				keys: fieldKeys,
+				kind: field.Type.Kind(),
+			})
+		}
+	}
+
+	return vars
+}
+
+// EnvConfigLayer returns the layer set by the FAN_* variables in environ, or false if none are set. Lists are
+// separated by os.PathListSeparator.
+func EnvConfigLayer(environ []string) (ConfigLayer, bool) {
+	values := make(map[string]string)
+	for _, entry := range environ {
+		if key, value, found := strings.Cut(entry, "="); found && strings.HasPrefix(key, EnvPrefix) {
+			values[key] = value
+		}
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	found := false
+
+	for _, v := range envVars(reflect.TypeOf(Config{}), EnvPrefix, nil) {
+		value, ok := values[v.name]
+		if !ok {
+			continue
+		}
+
+		node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+
+		switch v.kind {
+		case reflect.String:
+			node.Tag = "!!str"
+		case reflect.Slice:
+			node = &yaml.Node{Kind: yaml.SequenceNode}
+			for _, item := range strings.Split(value, string(os.PathListSeparator)) {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+			}
+		}
+
+		parent := root
+		for _, key := range v.keys[:len(v.keys)-1] {
+			parent = ensureMapping(parent, key)
+		}
+
+		setMappingValue(parent, v.keys[len(v.keys)-1], node)
+		found = true
+	}
+
+	return ConfigLayer{
+		Name: LayerEnv,
+		node: root,
+	}, found
+}
+
+// mappingValue returns the value of key in mapping, or nil if it is not set.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+func deleteMappingKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// ensureMapping returns the mapping at key in mapping, replacing any value which is not a mapping.
+func ensureMapping(mapping *yaml.Node, key string) *yaml.Node {
+	if value := mappingValue(mapping, key); value != nil && value.Kind == yaml.MappingNode {
+		return value
+	}
+
+	value := &yaml.Node{Kind: yaml.MappingNode}
+	setMappingValue(mapping, key, value)
+
+	return value
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null"
+}
+
+// atomicMappings are the mappings whose values are replaced as a whole rather than merged, so that an alias from one
+// layer is never combined with the settings of an alias from another.
+var atomicMappings = map[string]bool{
+	"aliases": true,
+}
+
+// mergeNodes sets each value of src in dst, merging nested mappings key by key and recording origin for every value
+// which is set.
+func mergeNodes(dst *yaml.Node, src *yaml.Node, prefix string, origins map[string]ConfigLayer, origin ConfigLayer) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i].Value, src.Content[i+1]
+		if isNull(value) {
+			continue
+		}
+
+		path := prefix + key
+
+		existing := mappingValue(dst, key)
+		if value.Kind == yaml.MappingNode && existing != nil && existing.Kind == yaml.MappingNode && !atomicMappings[strings.TrimSuffix(prefix, ".")] {
+			mergeNodes(existing, value, path+".", origins, origin)
+			continue
+		}
+
+		for p := range origins {
+			if strings.HasPrefix(p, path+".") {
+				delete(origins, p)
+			}
+		}
+
+		setMappingValue(dst, key, value)
+		origins[path] = origin
+
+		if value.Kind == yaml.MappingNode {
+			recordOrigins(value, path+".", origins, origin)
+		}
+	}
+}
+
+func recordOrigins(mapping *yaml.Node, prefix string, origins map[string]ConfigLayer, origin ConfigLayer) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		path := prefix + mapping.Content[i].Value
+		origins[path] = origin
+
+		if value := mapping.Content[i+1]; value.Kind == yaml.MappingNode {
+			recordOrigins(value, path+".", origins, origin)
+		}
+	}
+}
+
+// projectRestrictedKeys are the dotted keys which the project layer cannot set. Any repository can ship a project
+// config, so it must not be able to decide which targets are trusted, where the cache is kept, or where and with which
+// credentials targets are fetched.
+var projectRestrictedKeys = []string{
+	"cachedir",
+	"trust",
+	"s3.endpoint",
+	"s3.profile",
+	"oci",
+	"ssh",
+}
+
+// checkProjectLayer returns an error naming each restricted key set by the project layer.
+func checkProjectLayer(layer ConfigLayer) error {
+	var found []string
+
+	for _, key := range projectRestrictedKeys {
+		node := layer.node
+
+		for _, part := range strings.Split(key, ".") {
+			if node = mappingValue(node, part); node == nil || node.Kind != yaml.MappingNode {
+				break
+			}
+		}
+
+		if node != nil && !isNull(node) {
+			found = append(found, key)
+		}
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("%s: project config cannot set '%s', set them in the user or system config instead", layer.Path, strings.Join(found, "', '"))
+	}
+
+	return nil
+}
+
+// MergeConfigLayers merges layers in order, so that each layer overrides the values of the layers before it.
+func MergeConfigLayers(layers ...ConfigLayer) (LayeredConfig, error) {
+	merged := LayeredConfig{
+		Origins: make(map[string]ConfigLayer),
+		node:    &yaml.Node{Kind: yaml.MappingNode},
+	}
+
+	for _, layer := range layers {
+		mergeNodes(merged.node, layer.node, "", merged.Origins, layer)
+	}
+
+	if err := merged.node.Decode(&merged.Config); err != nil {
+		return LayeredConfig{}, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	if merged.Config.Aliases == nil {
+		merged.Config.Aliases = make(map[string]Alias)
+	}
+
+	if merged.Config.Catalogs == nil {
+		merged.Config.Catalogs = make(map[string]string)
+	}
+
+	return merged, nil
+}
+
+// LoadConfig merges the default config, the system config, the user config at userPath, the project config at
+// projectPath (if not empty), and the environment, in order of increasing precedence. The project config may not set
+// any of projectRestrictedKeys.
+func LoadConfig(userPath string, projectPath string, environ []string) (LayeredConfig, error) {
+	defaults, err := defaultLayer()
+	if err != nil {
+		return LayeredConfig{}, err
+	}
+
+	layers := []ConfigLayer{defaults}
+
+	files := []struct {
+		name string
+		path string
+	}{
+		{LayerSystem, SystemConfigPath},
+		{LayerUser, userPath},
+		{LayerProject, projectPath},
+	}
+
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+
+		layer, found, err := ReadConfigLayer(file.name, file.path)
+		if err != nil {
+			return LayeredConfig{}, err
+		} else if !found {
+			continue
+		}
+
+		if file.name == LayerProject {
+			if err := checkProjectLayer(layer); err != nil {
+				return LayeredConfig{}, err
+			}
+		}
+
+		layers = append(layers, layer)
+	}
+
+	if layer, found := EnvConfigLayer(environ); found {
+		if err := ValidateConfigNode(layer.node); err != nil {
+			return LayeredConfig{}, configSourceError("environment", err)
+		}
+
+		layers = append(layers, layer)
+	}
+
+	return MergeConfigLayers(layers...)
+}
+
+// origin returns the layer which set the value at path, or which set its closest parent.
+func (c LayeredConfig) origin(path string) ConfigLayer {
+	for {
+		if origin, found := c.Origins[path]; found {
+			return origin
+		}
+
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return ConfigLayer{Name: LayerDefault}
+		}
+
+		path = path[:i]
+	}
+}
+
+// WriteOrigins writes each effective value as a dotted key along with the layer which set it.
+func (c LayeredConfig) WriteOrigins(w io.Writer) error {
+	type line struct {
+		key    string
+		value  string
+		origin string
+	}
+
+	var lines []line
+
+	var walk func(node *yaml.Node, prefix string)
+	walk = func(node *yaml.Node, prefix string) {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			path := prefix + node.Content[i].Value
+			value := node.Content[i+1]
+
+			switch value.Kind {
+			case yaml.MappingNode:
+				walk(value, path+".")
+			case yaml.SequenceNode:
+				items := make([]string, 0, len(value.Content))
+				for _, item := range value.Content {
+					items = append(items, item.Value)
+				}
+
+				lines = append(lines, line{path, "[" + strings.Join(items, ", ") + "]", c.origin(path).Origin()})
+			default:
+				lines = append(lines, line{path, value.Value, c.origin(path).Origin()})
+			}
+		}
+	}
+
+	walk(c.node, "")
+
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].key < lines[j].key
+	})
+
+	for _, l := range lines {
+		if _, err := fmt.Fprintf(w, "%s: %s  # %s\n", l.key, l.value, l.origin); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateConfigFile applies update to the root mapping of the config at path and writes it back, preserving any value
+// or comment it does not touch. A missing config is created.
+func updateConfigFile(path string, update func(root *yaml.Node)) error {
+	var doc yaml.Node
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config '%s': %w", path, err)
+	}
+
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("failed to parse config '%s': expected a mapping", path)
+	}
+
+	update(root)
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.WriteFile(path, out, 0o644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLockFileName is the name of the lockfile looked for in the working directory.
+const DefaultLockFileName = "fan.lock"
+
+// LockedTarget is an alias resolved to the exact content it is run with.
+type LockedTarget struct {
+	Url    string `yaml:"url"`
+	Sha256 string `yaml:"sha256"`
+	Size   int64  `yaml:"size"`
+
+	// Entrypoint is the executable which was selected from a directory target when it was locked.
+	Entrypoint string `yaml:"entrypoint,omitempty"`
+}
+
+// Lockfile pins aliases and fanfile scripts to the content they resolved to when they were locked, so that every run of an alias
+// executes byte-identical content.
+type Lockfile struct {
+	Aliases map[string]LockedTarget `yaml:"aliases"`
+
+	// Path is the location the lockfile is read from and written to.
+	Path string `yaml:"-"`
+}
+
+// ReadLockfile reads the lockfile at path, a missing lockfile is treated as empty.
+func ReadLockfile(path string) (Lockfile, error) {
+	lock := Lockfile{
+		Aliases: make(map[string]LockedTarget),
+		Path:    path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lock, nil
+	} else if err != nil {
+		return Lockfile{}, fmt.Errorf("failed to read lockfile: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return Lockfile{}, fmt.Errorf("failed to parse lockfile: %w", err)
+	}
+
+	if lock.Aliases == nil {
+		lock.Aliases = make(map[string]LockedTarget)
+	}
+
+	return lock, nil
+}
+
+func (l Lockfile) Write() error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lockfile: %w", err)
+	}
+
+	if err := os.WriteFile(l.Path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+
+	return nil
+}
+
+// lockUrl fetches the target at u and returns the digest and size of its executable.
+func lockUrl(u string) (LockedTarget, error) {
+	target := fan.Target{
+		Url: u,
+	}
+
+	p := fan.TempPath()
+	defer os.RemoveAll(p)
+
+	if err := fan.DefaultRegistry.Fetch(&target, p); err != nil {
+		return LockedTarget{}, fmt.Errorf("failed to fetch url '%s': %w", u, err)
+	}
+
+	// the fetched target knows which entrypoint was selected, even if the url does not name one
+	info, err := os.Stat(filepath.Join(p, target.EntrypointPath()))
+	if err != nil {
+		return LockedTarget{}, fmt.Errorf("failed to stat executable: %w", err)
+	}
+
+	if info.IsDir() {
+		return LockedTarget{}, fmt.Errorf("url '%s' does not select an executable, add an entrypoint to lock it", u)
+	}
+
+	return LockedTarget{
+		Url:        u,
+		Sha256:     target.Sha256,
+		Size:       info.Size(),
+		Entrypoint: target.Entrypoint,
+	}, nil
+}
+
+// checkSelection returns an error if an explicit entrypoint or digest selects different content than was locked for
+// name.
+func (l LockedTarget) checkSelection(name string, entrypoint string, digest string) error {
+	if entrypoint != "" && path.Clean(entrypoint) != l.Entrypoint {
+		return fmt.Errorf("entrypoint '%s' differs from the entrypoint locked for '%s', run 'fan lock --update %s' to lock it", entrypoint, name, name)
+	}
+
+	if digest == "" {
+		return nil
+	}
+
+	digest, err := fan.ParseSha256(digest)
+	if err != nil {
+		return err
+	}
+
+	if digest != l.Sha256 {
+		return fmt.Errorf("sha256 '%s' differs from the sha256 locked for '%s', run 'fan lock --update %s' to lock it", digest, name, name)
+	}
+
+	return nil
+}
+
+// lockedUrl returns the url to run for name, pinned to its locked digest if it is in the lockfile.
+func lockedUrl(name string) (string, *LockedTarget, error) {
+	url, err := resolveUrl(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	locked, found := lockfile.Aliases[name]
+	if !found {
+		return url, nil, nil
+	}
+
+	if locked.Url != url {
+		log.Warn("alias has changed since it was locked, run 'fan lock --update' to use the new url", "alias", name, "locked", locked.Url, "url", url)
+	}
+
+	if url, err = fan.WithSha256(locked.Url, locked.Sha256); err != nil {
+		return "", nil, fmt.Errorf("invalid lockfile entry for '%s': %w", name, err)
+	}
+
+	return url, &locked, nil
+}
+
+func actionLock(ctx *cli.Context) error {
+	aliases := ctx.StringSlice("update")
+	all := len(aliases) == 0
+
+	if all {
+		aliases = names()
+
+		// versions of templates and catalog aliases are only locked if they were locked before
+		for ref := range lockfile.Aliases {
+			if _, isNamed := named(ref); !isNamed {
+				if _, found, _ := lookup(ref); found {
+					aliases = append(aliases, ref)
+				}
+			}
+		}
+		sort.Strings(aliases)
+
+		lockfile.Aliases = make(map[string]LockedTarget, len(aliases))
+	}
+
+	for _, alias := range aliases {
+		_, found, err := lookup(alias)
+		if all && errors.Is(err, ErrVersionRequired) {
+			log.Info("skipping alias without a version, lock a version with 'fan lock --update <alias>@<version>'", "alias", alias)
+			continue
+		} else if err != nil {
+			return cli.Exit(err.Error(), 1)
+		} else if !found {
+			return cli.Exit(fmt.Sprintf("no such alias or script '%s'", alias), 1)
+		}
+
+		url, err := resolveUrl(alias)
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+
+		locked, err := lockUrl(url)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("failed to lock '%s': %s", alias, err), 1)
+		}
+
+		lockfile.Aliases[alias] = locked
+	}
+
+	if err := lockfile.Write(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	return nil
+}
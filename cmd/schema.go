@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigSchema is the JSON Schema which configs are validated against.
+//
+//go:embed config.schema.json
+var ConfigSchema []byte
+
+// schema is the subset of JSON Schema used by ConfigSchema.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Pattern              string             `json:"pattern"`
+	AnyOf                []*schema          `json:"anyOf"`
+	Defs                 map[string]*schema `json:"$defs"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes is the value of a schema's 'type', which may be a single type or a list of types.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("schema type must be a string or list of strings: %w", err)
+	}
+
+	*t = multiple
+	return nil
+}
+
+// ValidationError is a value in a yaml document which does not match its schema.
+type ValidationError struct {
+	// Path is the dotted path of the value.
+	Path string
+
+	// Line and Column locate the value in its document, they are zero if the value was not read from a document.
+	Line   int
+	Column int
+
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "<root>"
+	}
+
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", path, e.Message)
+	}
+
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, path, e.Message)
+}
+
+// ValidationErrors are all the errors found while validating a document.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+type validator struct {
+	root   *schema
+	errors ValidationErrors
+}
+
+func parseSchema(data []byte) (*schema, error) {
+	var root schema
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	return &root, nil
+}
+
+// resolve follows the '$ref' of s, only references to '#/$defs/<name>' are supported.
+func (v *validator) resolve(s *schema) (*schema, error) {
+	for s.Ref != "" {
+		name, found := strings.CutPrefix(s.Ref, "#/$defs/")
+		if !found {
+			return nil, fmt.Errorf("unsupported schema reference '%s'", s.Ref)
+		}
+
+		def, found := v.root.Defs[name]
+		if !found {
+			return nil, fmt.Errorf("unknown schema reference '%s'", s.Ref)
+		}
+
+		s = def
+	}
+
+	return s, nil
+}
+
+func (v *validator) fail(node *yaml.Node, path string, format string, args ...any) {
+	v.errors = append(v.errors, ValidationError{
+		Path:    path,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// nodeType returns the JSON Schema type of node.
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+
+	switch node.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func typeMatches(expected schemaTypes, actual string) bool {
+	if len(expected) == 0 {
+		return true
+	}
+
+	for _, t := range expected {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *validator) validate(s *schema, node *yaml.Node, path string) error {
+	s, err := v.resolve(s)
+	if err != nil {
+		return err
+	}
+
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	actual := nodeType(node)
+
+	// a key without a value leaves it unset, so null is accepted anywhere
+	if actual == "null" {
+		return nil
+	}
+
+	if len(s.AnyOf) > 0 {
+		return v.validateAnyOf(s.AnyOf, node, path)
+	}
+
+	if !typeMatches(s.Type, actual) {
+		v.fail(node, path, "expected %s but found %s", strings.Join(s.Type, " or "), actual)
+		return nil
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		return v.validateObject(s, node, path)
+	case yaml.SequenceNode:
+		if s.Items == nil {
+			return nil
+		}
+
+		for i, item := range node.Content {
+			if err := v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if s.Pattern == "" || actual != "string" {
+			return nil
+		}
+
+		if s.pattern == nil {
+			if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+				return fmt.Errorf("invalid schema pattern '%s': %w", s.Pattern, err)
+			}
+		}
+
+		if !s.pattern.MatchString(node.Value) {
+			v.fail(node, path, "'%s' does not match the pattern '%s'", node.Value, s.Pattern)
+		}
+	}
+
+	return nil
+}
+
+// validateAnyOf accepts node if it matches any of options. Otherwise the errors of the first option of the same type
+// as node are reported, since they are the most likely to describe what was intended.
+func (v *validator) validateAnyOf(options []*schema, node *yaml.Node, path string) error {
+	var closest ValidationErrors
+	var types []string
+
+	for _, option := range options {
+		resolved, err := v.resolve(option)
+		if err != nil {
+			return err
+		}
+
+		sub := validator{root: v.root}
+		if err := sub.validate(resolved, node, path); err != nil {
+			return err
+		}
+
+		if len(sub.errors) == 0 {
+			return nil
+		}
+
+		types = append(types, resolved.Type...)
+
+		if closest == nil && typeMatches(resolved.Type, nodeType(node)) {
+			closest = sub.errors
+		}
+	}
+
+	if closest != nil {
+		v.errors = append(v.errors, closest...)
+	} else {
+		v.fail(node, path, "expected %s but found %s", strings.Join(types, " or "), nodeType(node))
+	}
+
+	return nil
+}
+
+func (v *validator) validateObject(s *schema, node *yaml.Node, path string) error {
+	var additional *schema
+	allowAdditional := true
+
+	switch raw := strings.TrimSpace(string(s.AdditionalProperties)); raw {
+	case "", "true":
+	case "false":
+		allowAdditional = false
+	default:
+		additional = &schema{}
+		if err := json.Unmarshal(s.AdditionalProperties, additional); err != nil {
+			return fmt.Errorf("failed to parse schema: %w", err)
+		}
+	}
+
+	for _, required := range s.Required {
+		if value := mappingValue(node, required); value == nil || isNull(value) {
+			v.fail(node, path, "missing required key '%s'", required)
+		}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		keyPath := key.Value
+		if path != "" {
+			keyPath = path + "." + key.Value
+		}
+
+		if property, found := s.Properties[key.Value]; found {
+			if err := v.validate(property, value, keyPath); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		switch {
+		case additional != nil:
+			if err := v.validate(additional, value, keyPath); err != nil {
+				return err
+			}
+		case !allowAdditional:
+			known := make([]string, 0, len(s.Properties))
+			for name := range s.Properties {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+
+			v.fail(key, keyPath, "unknown key, expected one of: %s", strings.Join(known, ", "))
+		}
+	}
+
+	return nil
+}
+
+// ValidateConfigNode validates the mapping at the root of a config document against ConfigSchema.
+func ValidateConfigNode(node *yaml.Node) error {
+	root, err := parseSchema(ConfigSchema)
+	if err != nil {
+		return err
+	}
+
+	v := validator{root: root}
+	if err := v.validate(root, node, ""); err != nil {
+		return err
+	}
+
+	if len(v.errors) > 0 {
+		return v.errors
+	}
+
+	return nil
+}
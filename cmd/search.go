@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// maxSearchSize is the largest cached executable whose content is searched.
+	maxSearchSize = 1 << 20
+
+	scoreExact     = 1000
+	scoreSubstring = 500
+	scoreBoundary  = 100
+
+	// scoreSubsequenceMax keeps a match of scattered characters below any substring match.
+	scoreSubsequenceMax = scoreSubstring - 1
+
+	// scoreContent is the score of a term found in the content of a cached executable, which ranks below any match on
+	// the alias itself.
+	scoreContent = 50
+)
+
+// isWordBoundary reports whether a word may start after r.
+func isWordBoundary(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// fuzzyScore returns how well term matches s ignoring case, or zero if it does not. Exact matches score highest,
+// followed by substrings and then by the characters of term appearing in order in s. Matches at the start of a word,
+// and runs of consecutive characters, score higher.
+func fuzzyScore(term string, s string) int {
+	term, s = strings.ToLower(term), strings.ToLower(s)
+
+	if term == "" || s == "" {
+		return 0
+	}
+
+	if term == s {
+		return scoreExact
+	}
+
+	if i := strings.Index(s, term); i >= 0 {
+		score := scoreSubstring - min(i, scoreBoundary-1)
+
+		if previous, _ := utf8.DecodeLastRuneInString(s[:i]); i == 0 || isWordBoundary(previous) {
+			score += scoreBoundary
+		}
+
+		return score
+	}
+
+	runes := []rune(s)
+	score, run, next := 0, 0, 0
+
+	for _, r := range term {
+		found := false
+
+		for ; next < len(runes); next++ {
+			if runes[next] != r {
+				run = 0
+				continue
+			}
+
+			score += 10 + run*5
+			if next == 0 || isWordBoundary(runes[next-1]) {
+				score += 10
+			}
+
+			run++
+			next++
+			found = true
+			break
+		}
+
+		if !found {
+			return 0
+		}
+	}
+
+	return min(score, scoreSubsequenceMax)
+}
+
+// Score returns how well term fuzzily matches the alias called name, or zero if it does not match. Matches on the name
+// are weighted above tags, then the description, and then the url.
+func (a Alias) Score(name string, term string) int {
+	score := 4 * fuzzyScore(term, name)
+
+	for _, tag := range a.Tags {
+		score = max(score, 3*fuzzyScore(term, tag))
+	}
+
+	score = max(score, 2*fuzzyScore(term, a.Description))
+	score = max(score, fuzzyScore(term, a.Url))
+
+	return score
+}
+
+// searchContent returns the first line of the executable at path containing term ignoring case, and its line number.
+// Executables which are too large or look to be binary are not searched.
+func searchContent(path string, term string) (int, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if info.IsDir() || info.Size() > maxSearchSize {
+		return 0, "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 {
+		return 0, "", nil
+	}
+
+	term = strings.ToLower(term)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for n := 1; scanner.Scan(); n++ {
+		if line := scanner.Text(); strings.Contains(strings.ToLower(line), term) {
+			return n, strings.TrimSpace(line), nil
+		}
+	}
+
+	return 0, "", scanner.Err()
+}
+
+// searchResult is a script or alias which matched a search.
+type searchResult struct {
+	listing
+
+	url    string
+	status string
+	score  int
+
+	// line and lineNumber are the first line of the cached executable which matched, if any.
+	line       string
+	lineNumber int
+}
+
+// cacheStatus describes whether the target at url is cached, and the path to its executable if it is.
+func cacheStatus(url string) (string, string) {
+	_, executable, err := fanCache.GetTargetForUrl(url)
+	switch {
+	case err == nil:
+		return "cached", executable
+	case errors.Is(err, cache.ErrExpired):
+		return "expired", executable
+	case errors.Is(err, cache.ErrNotFound):
+		return "not cached", ""
+	default:
+		return "unknown", ""
+	}
+}
+
+func actionSearch(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return cli.Exit("no search term specified", 1)
+	}
+
+	term := strings.Join(ctx.Args().Slice(), " ")
+
+	var results []searchResult
+
+	for _, l := range listings() {
+		result := searchResult{
+			listing: l,
+			score:   l.alias.Score(l.name, term),
+			status:  "template",
+		}
+
+		url, err := displayUrl(l.alias)
+		if err != nil {
+			log.Warn("skipping invalid alias", "alias", l.name, "err", err)
+			continue
+		}
+		result.url = url
+
+		var executable string
+		if !l.alias.IsTemplate() {
+			result.status, executable = cacheStatus(url)
+		}
+
+		if ctx.Bool("content") && executable != "" {
+			n, line, err := searchContent(executable, term)
+			if err != nil {
+				log.Warn("failed to search cached executable", "alias", l.name, "err", err)
+			} else if n > 0 {
+				result.line, result.lineNumber = line, n
+				result.score = max(result.score, scoreContent)
+			}
+		}
+
+		if result.score > 0 {
+			results = append(results, result)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+
+		return results[i].name < results[j].name
+	})
+
+	if limit := ctx.Int("limit"); limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	maxNameLen, maxStatusLen := 0, 0
+	for _, result := range results {
+		maxNameLen = max(maxNameLen, len(result.name))
+		maxStatusLen = max(maxStatusLen, len(result.status))
+	}
+
+	detailPadding := strings.Repeat(" ", maxNameLen+2)
+	out := ctx.App.Writer
+
+	for _, result := range results {
+		fmt.Fprintf(out, "%-*s  %-*s  %s\n", maxNameLen, result.name, maxStatusLen, result.status, result.url)
+
+		if result.alias.Description != "" {
+			fmt.Fprintf(out, "%s%s\n", detailPadding, result.alias.Description)
+		}
+
+		if result.lineNumber > 0 {
+			fmt.Fprintf(out, "%s%d: %s\n", detailPadding, result.lineNumber, result.line)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	type testCase struct {
+		Name     string
+		Term     string
+		S        string
+		Expected int
+	}
+
+	cases := []testCase{
+		{Name: "Exact", Term: "kubectl", S: "kubectl", Expected: scoreExact},
+		{Name: "IgnoresCase", Term: "KUBE", S: "kubectl", Expected: scoreSubstring + scoreBoundary},
+		{Name: "Substring", Term: "ctl", S: "kubectl", Expected: scoreSubstring - 4},
+		{Name: "SubstringAtWord", Term: "ctl", S: "kube-ctl", Expected: scoreSubstring - 5 + scoreBoundary},
+		{Name: "SubstringFarIn", Term: "z", S: strings.Repeat("a", 200) + "z", Expected: scoreSubstring - (scoreBoundary - 1)},
+
+		// k starts a word and each following character extends a run: 20 + 10 + 15 + 20
+		{Name: "Subsequence", Term: "kctl", S: "kubectl", Expected: 65},
+		// both characters start a word: 20 + 20
+		{Name: "SubsequenceAtWords", Term: "kc", S: "kube-ctl", Expected: 40},
+		{Name: "SubsequenceCapped", Term: "abcdefghijklmnopqrstuvwxyz", S: "a b c d e f g h i j k l m n o p q r s t u v w x y z", Expected: scoreSubsequenceMax},
+
+		{Name: "OutOfOrder", Term: "lk", S: "kubectl", Expected: 0},
+		{Name: "Missing", Term: "xyz", S: "kubectl", Expected: 0},
+		{Name: "EmptyTerm", Term: "", S: "kubectl", Expected: 0},
+		{Name: "EmptyString", Term: "kubectl", S: "", Expected: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			assert.Equal(t, c.Expected, fuzzyScore(c.Term, c.S))
+		})
+	}
+}
+
+func TestSearchContent(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, data, 0o755))
+		return path
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		path := write("script", []byte("#!/usr/bin/env bash\n  # Deploys the pods  \nexit 0\n"))
+
+		n, line, err := searchContent(path, "deploys")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, "# Deploys the pods", line)
+
+		n, _, err = searchContent(path, "missing")
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		path := write("binary", []byte("\x7fELF\x00\x00deploys\n"))
+
+		n, _, err := searchContent(path, "deploys")
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		path := write("large", append([]byte("deploys\n"), bytes.Repeat([]byte("a"), maxSearchSize)...))
+
+		n, _, err := searchContent(path, "deploys")
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("Directory", func(t *testing.T) {
+		n, _, err := searchContent(dir, "deploys")
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("NotExist", func(t *testing.T) {
+		_, _, err := searchContent(filepath.Join(dir, "missing"), "deploys")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -3,16 +3,28 @@ module github.com/joshmeranda/fan
 go 1.21.3
 
 require (
+	aead.dev/minisign v0.2.0
+	github.com/ProtonMail/go-crypto v1.1.6
+	github.com/klauspost/compress v1.17.11
 	github.com/phayes/freeport v0.0.0-20220201140144-74d24b5ae9f5
+	github.com/pkg/sftp v1.13.6
 	github.com/stretchr/testify v1.10.0
+	github.com/ulikunitz/xz v0.5.12
 	github.com/urfave/cli/v2 v2.27.1
+	golang.org/x/crypto v0.33.0
+)
+
+require (
+	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 )
 
 require (
-	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/cespare/xxhash v1.1.0
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
 	gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,309 @@
+package fan
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// ArchiveFormat identifies how an archive target is unpacked.
+type ArchiveFormat string
+
+const (
+	ArchiveNone   ArchiveFormat = ""
+	ArchiveTar    ArchiveFormat = "tar"
+	ArchiveTarGz  ArchiveFormat = "tar.gz"
+	ArchiveTarXz  ArchiveFormat = "tar.xz"
+	ArchiveTarZst ArchiveFormat = "tar.zst"
+	ArchiveZip    ArchiveFormat = "zip"
+
+	entrypointFragmentKey = "entry"
+)
+
+var (
+	ErrNoEntrypoint = errors.New("no entrypoint")
+)
+
+var archiveSuffixes = []struct {
+	suffix string
+	format ArchiveFormat
+}{
+	{".tar.gz", ArchiveTarGz},
+	{".tgz", ArchiveTarGz},
+	{".tar.xz", ArchiveTarXz},
+	{".txz", ArchiveTarXz},
+	{".tar.zst", ArchiveTarZst},
+	{".tzst", ArchiveTarZst},
+	{".tar", ArchiveTar},
+	{".zip", ArchiveZip},
+}
+
+// archiveFormatForName returns the archive format and suffix of the given file name, or ArchiveNone if it is not an
+// archive.
+func archiveFormatForName(name string) (ArchiveFormat, string) {
+	lower := strings.ToLower(name)
+
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.format, name[len(name)-len(s.suffix):]
+		}
+	}
+
+	return ArchiveNone, ""
+}
+
+// ArchiveFormatForUrl returns the archive format of the target at u, or ArchiveNone if it is not an archive.
+func ArchiveFormatForUrl(u *url.URL) ArchiveFormat {
+	if IsGitUrl(u) {
+		return ArchiveNone
+	}
+
+	format, _ := archiveFormatForName(path.Base(u.Path))
+	return format
+}
+
+// EntrypointFromUrl returns the entrypoint selected by an '#entry=<path>' fragment, or an empty string if there is none.
+func EntrypointFromUrl(u *url.URL) string {
+	return fragmentValue(u, entrypointFragmentKey)
+}
+
+// WithEntrypoint returns raw with its entrypoint fragment set to entrypoint.
+func WithEntrypoint(raw string, entrypoint string) (string, error) {
+	return withFragmentValue(raw, entrypointFragmentKey, entrypoint)
+}
+
+// safeJoin joins name to dir, failing if the result would escape dir.
+func safeJoin(dir string, name string) (string, error) {
+	cleaned := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid archive entry '%s'", name)
+	}
+
+	joined := filepath.Join(dir, filepath.FromSlash(cleaned))
+	if !strings.HasPrefix(joined, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry '%s' escapes destination", name)
+	}
+
+	return joined, nil
+}
+
+// relativeName returns the slash separated path of dst relative to dir.
+func relativeName(dir string, dst string) string {
+	rel, err := filepath.Rel(dir, dst)
+	if err != nil {
+		return filepath.ToSlash(dst)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+func writeArchiveFile(dst string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+func unpackTar(r io.Reader, dir string) ([]string, error) {
+	var files []string
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		dst, err := safeJoin(dir, header.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, 0o755); err != nil {
+				return nil, fmt.Errorf("failed to create directory: %w", err)
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(dst, reader, header.FileInfo().Mode()); err != nil {
+				return nil, err
+			}
+			files = append(files, relativeName(dir, dst))
+		case tar.TypeSymlink:
+			resolved := filepath.Join(filepath.Dir(dst), filepath.FromSlash(header.Linkname))
+			if filepath.IsAbs(header.Linkname) || !strings.HasPrefix(resolved, dir+string(filepath.Separator)) {
+				return nil, fmt.Errorf("archive link '%s' escapes destination", header.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+				return nil, fmt.Errorf("failed to create directory: %w", err)
+			}
+			if err := os.Symlink(header.Linkname, dst); err != nil {
+				return nil, fmt.Errorf("failed to create link: %w", err)
+			}
+			files = append(files, relativeName(dir, dst))
+		}
+	}
+
+	return files, nil
+}
+
+func unpackZip(archive string, dir string) ([]string, error) {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer reader.Close()
+
+	var files []string
+
+	for _, f := range reader.File {
+		dst, err := safeJoin(dir, f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(dst, 0o755); err != nil {
+				return nil, fmt.Errorf("failed to create directory: %w", err)
+			}
+			continue
+		}
+
+		in, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		err = writeArchiveFile(dst, in, f.Mode())
+		in.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, relativeName(dir, dst))
+	}
+
+	return files, nil
+}
+
+// Unpack extracts archive into dir, returning the sorted paths of the files it contained relative to dir.
+func Unpack(format ArchiveFormat, archive string, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	dir = filepath.Clean(dir)
+
+	var files []string
+
+	if format == ArchiveZip {
+		var err error
+		if files, err = unpackZip(archive, dir); err != nil {
+			return nil, err
+		}
+	} else {
+		f, err := os.Open(archive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer f.Close()
+
+		var r io.Reader
+
+		switch format {
+		case ArchiveTar:
+			r = f
+		case ArchiveTarGz:
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress archive: %w", err)
+			}
+			defer gz.Close()
+			r = gz
+		case ArchiveTarXz:
+			if r, err = xz.NewReader(f); err != nil {
+				return nil, fmt.Errorf("failed to decompress archive: %w", err)
+			}
+		case ArchiveTarZst:
+			zr, err := zstd.NewReader(f)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress archive: %w", err)
+			}
+			defer zr.Close()
+			r = zr
+		default:
+			return nil, fmt.Errorf("unsupported archive format '%s'", format)
+		}
+
+		if files, err = unpackTar(r, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// unpackTarget unpacks the archive fetched for target into dir, recording the archive layout and entrypoint on target.
+// If no entrypoint was selected and the archive contains a single file, that file is used.
+func unpackTarget(target *Target, format ArchiveFormat, archive string, dir string) error {
+	files, err := Unpack(format, archive, dir)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	entrypoint := EntrypointFromUrl(u)
+	if entrypoint == "" {
+		entrypoint = target.Entrypoint
+	}
+
+	switch {
+	case entrypoint != "":
+		entrypoint = path.Clean(entrypoint)
+
+		i := sort.SearchStrings(files, entrypoint)
+		if i == len(files) || files[i] != entrypoint {
+			return fmt.Errorf("entrypoint '%s' not found in archive", entrypoint)
+		}
+	case len(files) == 1:
+		entrypoint = files[0]
+	default:
+		return fmt.Errorf("%w: archive contains %d files, select one with '#entry=<path>' or --entrypoint", ErrNoEntrypoint, len(files))
+	}
+
+	target.Files = files
+	target.Entrypoint = entrypoint
+
+	return nil
+}
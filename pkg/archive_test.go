@@ -0,0 +1,144 @@
+package fan_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+var archiveFiles = map[string]string{
+	"bin/tool":   "#!/usr/bin/env bash\nexit 0",
+	"lib/common": "echo common",
+}
+
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0o755,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}))
+		tw.Write([]byte(content))
+	}
+
+	tw.Close()
+	gz.Close()
+
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for name, content := range files {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(0o755)
+
+		w, err := zw.CreateHeader(header)
+		assert.NoError(t, err)
+		w.Write([]byte(content))
+	}
+
+	zw.Close()
+
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func TestArchiveTargets(t *testing.T) {
+	dir := t.TempDir()
+
+	tarGz := filepath.Join(dir, "tool.tar.gz")
+	writeTarGz(t, tarGz, archiveFiles)
+
+	zipped := filepath.Join(dir, "tool.zip")
+	writeZip(t, zipped, archiveFiles)
+
+	for _, archive := range []string{tarGz, zipped} {
+		t.Run(filepath.Base(archive), func(t *testing.T) {
+			target := fan.Target{Url: "file://" + archive + "#entry=bin/tool"}
+			dst := filepath.Join(t.TempDir(), "tool")
+
+			err := fan.DefaultRegistry.Fetch(&target, dst)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"bin/tool", "lib/common"}, target.Files)
+			assert.Equal(t, "bin/tool", target.Entrypoint)
+			assert.Equal(t, filepath.Join("tool", "bin", "tool"), target.ExecutablePath())
+
+			data, err := os.ReadFile(filepath.Join(dst, "bin", "tool"))
+			assert.NoError(t, err)
+			assert.Equal(t, archiveFiles["bin/tool"], string(data))
+		})
+	}
+
+	t.Run("NoEntrypoint", func(t *testing.T) {
+		target := fan.Target{Url: "file://" + tarGz}
+
+		err := fan.DefaultRegistry.Fetch(&target, filepath.Join(t.TempDir(), "tool"))
+		assert.ErrorIs(t, err, fan.ErrNoEntrypoint)
+	})
+
+	t.Run("SingleFile", func(t *testing.T) {
+		single := filepath.Join(t.TempDir(), "single.tar.gz")
+		writeTarGz(t, single, map[string]string{"run.sh": "exit 0"})
+
+		target := fan.Target{Url: "file://" + single}
+
+		err := fan.DefaultRegistry.Fetch(&target, filepath.Join(t.TempDir(), "single"))
+		assert.NoError(t, err)
+		assert.Equal(t, "run.sh", target.Entrypoint)
+	})
+
+	t.Run("ContainsEscapingEntry", func(t *testing.T) {
+		escaping := filepath.Join(t.TempDir(), "escaping.tar.gz")
+		writeTarGz(t, escaping, map[string]string{"../../evil": "exit 1"})
+
+		dst := filepath.Join(t.TempDir(), "escaping")
+		_, err := fan.Unpack(fan.ArchiveTarGz, escaping, dst)
+		assert.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dst, "evil"))
+	})
+
+	t.Run("CachedEntrypoint", func(t *testing.T) {
+		c := cache.NewDiskCache(t.TempDir())
+
+		_, _, err := cache.FetchTarget(c, fan.DefaultRegistry, fan.Target{Url: "file://" + tarGz + "#entry=bin/tool"}, nil)
+		assert.NoError(t, err)
+
+		_, executable, err := c.GetTargetForUrl("file://" + tarGz + "#entry=lib/common")
+		assert.NoError(t, err)
+		assert.FileExists(t, executable)
+		assert.Equal(t, "common", filepath.Base(executable))
+
+		_, _, err = c.GetTargetForUrl("file://" + tarGz + "#entry=../../../etc/passwd")
+		assert.ErrorContains(t, err, "outside of the archive")
+
+		_, _, err = c.GetTargetForUrl("file://" + tarGz + "#entry=bin/missing")
+		assert.ErrorContains(t, err, "not found in archive")
+	})
+
+	t.Run("WithEntrypoint", func(t *testing.T) {
+		u, err := fan.WithEntrypoint("https://example.com/tool.tar.gz", "bin/tool")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/tool.tar.gz#entry=bin/tool", u)
+
+		assert.Equal(t, fan.Target{Url: "https://example.com/tool.tar.gz"}.Hash(), fan.Target{Url: u}.Hash())
+	})
+}
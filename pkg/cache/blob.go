@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	fan "github.com/joshmeranda/fan/pkg"
+)
+
+const (
+	// DefaultBlobsDir is the directory within the cache which content is stored in by its digest.
+	DefaultBlobsDir = "blobs"
+
+	blobAlgorithm = "sha256"
+)
+
+// blobPath returns the path content with the given digest is stored at.
+func (c *diskCache) blobPath(digest string) string {
+	return filepath.Join(c.CacheDir, DefaultBlobsDir, blobAlgorithm, digest)
+}
+
+// contentDigest returns the digest of the file or directory at path. The digest covers the mode as well as the content
+// of a file, and the path, mode, and content of everything within a directory, so that content is only shared if it
+// would be stored the same. The '.git' directory of a checkout is left out, since it changes on every fetch even when
+// the checked out files do not.
+func contentDigest(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	if info.Mode().IsRegular() {
+		content, err := fan.Sha256File(path)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "%s %s\n", info.Mode(), content)
+
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && p == filepath.Join(path, ".git") {
+			return fs.SkipDir
+		}
+
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var content string
+
+		switch {
+		case d.Type().IsRegular():
+			if content, err = fan.Sha256File(p); err != nil {
+				return err
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			if content, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(h, "%s %s %q\n", info.Mode(), content, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeBlob moves the content at path into the blobs directory, or removes it if content with the same digest is
+// already stored, and links path to the stored blob relative to entryDir, the directory path will be moved to. Content
+// which is itself a link, as for symlinked local targets, is left in place and an empty digest is returned.
+func (c *diskCache) storeBlob(path string, entryDir string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat content: %w", err)
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return "", nil
+	}
+
+	digest, err := contentDigest(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to digest content: %w", err)
+	}
+
+	blob := c.blobPath(digest)
+
+	if exists, err := PathExists(blob); err != nil {
+		return "", fmt.Errorf("failed checking for blob: %w", err)
+	} else if exists {
+		if err := os.RemoveAll(path); err != nil {
+			return "", fmt.Errorf("failed to remove duplicate content: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+			return "", fmt.Errorf("failed to create blobs directory: %w", err)
+		}
+
+		// the same content may have been stored by another writer since checking for it
+		if err := os.Rename(path, blob); err != nil {
+			if exists, _ := PathExists(blob); !exists {
+				return "", fmt.Errorf("failed to move content to blob: %w", err)
+			}
+
+			if err := os.RemoveAll(path); err != nil {
+				return "", fmt.Errorf("failed to remove duplicate content: %w", err)
+			}
+		} else if err := syncDir(filepath.Dir(blob)); err != nil {
+			return "", err
+		}
+	}
+
+	link, err := filepath.Rel(entryDir, blob)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine path to blob: %w", err)
+	}
+
+	if err := os.Symlink(link, path); err != nil {
+		return "", fmt.Errorf("failed to link to blob: %w", err)
+	}
+
+	return digest, nil
+}
+
+// removeUnreferencedBlobs removes every blob whose digest is not in referenced.
+func (c *diskCache) removeUnreferencedBlobs(referenced map[string]int) error {
+	dir := filepath.Join(c.CacheDir, DefaultBlobsDir, blobAlgorithm)
+
+	blobs, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read blobs directory: %w", err)
+	}
+
+	for _, blob := range blobs {
+		if referenced[blob.Name()] > 0 {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(dir, blob.Name())); err != nil {
+			return fmt.Errorf("failed to remove blob: %w", err)
+		}
+	}
+
+	return nil
+}
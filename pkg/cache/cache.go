@@ -1,3 +1,7 @@
+// Package cache stores fetched targets on disk so that they are only fetched again once they expire or change.
+//
+// The disk cache may be shared by several processes on platforms with advisory file locks (see LockingSupported). On
+// other platforms nothing is locked, so concurrent fetches or cleans of the same cache can corrupt it.
 package cache
 
 import (
@@ -11,8 +15,26 @@ type Cache interface {
 	// GetTargetForUrl returns the target and path to executable for the given url, or an error if one occured.
 	GetTargetForUrl(url string) (fan.Target, string, error)
 
+	// RefreshTarget updates the metadata of an already cached target, resetting the time it was cached at.
+	RefreshTarget(target fan.Target) error
+
+	// LockUrl waits for and takes the lock on the target for url, returning a function which releases it. Fetching a
+	// target while holding its lock exclusively lets concurrent fetches of the same target wait for the first rather
+	// than fetching it again, while any number of holders of a shared lock may use the cached target at once.
+	LockUrl(url string, exclusive bool) (func() error, error)
+
+	// StagingPath returns a new path for the target at url to be fetched to before it is added, on the same filesystem
+	// as the cache so that adding it does not need to copy it.
+	StagingPath(url string) (string, error)
+
+	// TouchTarget records that an already cached target was used.
+	TouchTarget(target fan.Target) error
+
 	InvalidateUrl(url string) error
 
+	// Evict removes the least recently used targets until the cache is no larger than maxSize.
+	Evict(maxSize fan.ByteSize) error
+
 	Clean() error
 }
 
@@ -31,10 +53,30 @@ func (c *noopCache) GetTargetForUrl(url string) (fan.Target, string, error) {
 	return fan.Target{}, "", ErrNotFound
 }
 
+func (c *noopCache) RefreshTarget(fan.Target) error {
+	return nil
+}
+
+func (c *noopCache) LockUrl(string, bool) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+func (c *noopCache) StagingPath(string) (string, error) {
+	return fan.TempPath(), nil
+}
+
+func (c *noopCache) TouchTarget(fan.Target) error {
+	return nil
+}
+
 func (c *noopCache) InvalidateUrl(string) error {
 	return nil
 }
 
+func (c *noopCache) Evict(fan.ByteSize) error {
+	return nil
+}
+
 func (c *noopCache) Clean() error {
 	return nil
 }
@@ -3,9 +3,13 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,19 +19,39 @@ import (
 
 const (
 	DefaultTargetMetadataFile = "metadata"
+
+	// DefaultStagingDir is the directory within the cache where targets are fetched to and entries are prepared
+	// before being moved into place, so that they are never seen partially written.
+	DefaultStagingDir = "staging"
+
+	stagedFetchPrefix = "fetch-"
 )
 
 var (
 	ErrNotFound = fmt.Errorf("not found")
+
+	// ErrExpired is returned alongside a cached target which has expired, so that it may be revalidated.
+	ErrExpired = fmt.Errorf("expired")
 )
 
 type diskCache struct {
 	CacheDir string
+
+	// MaxSize is the size the cache is kept under by evicting the least recently used targets, or zero if it is
+	// unbounded.
+	MaxSize fan.ByteSize
 }
 
 func NewDiskCache(cacheDir string) Cache {
+	return NewDiskCacheWithMaxSize(cacheDir, 0)
+}
+
+// NewDiskCacheWithMaxSize returns a disk cache which evicts the least recently used targets when adding a target would
+// make it larger than maxSize.
+func NewDiskCacheWithMaxSize(cacheDir string, maxSize fan.ByteSize) Cache {
 	return &diskCache{
 		CacheDir: cacheDir,
+		MaxSize:  maxSize,
 	}
 }
 
@@ -35,34 +59,251 @@ func (c *diskCache) pathForTarget(target fan.Target) string {
 	return path.Join(c.CacheDir, fmt.Sprintf("%d", target.Hash()))
 }
 
-// AddTarget adds the given target to the cache, using path as the on-disk executable location.
+// isEntryDir reports whether the directory called name in the cache directory holds a target.
+func isEntryDir(name string) bool {
+	return name != DefaultBlobsDir && name != DefaultLocksDir && name != DefaultStagingDir
+}
+
+func (c *diskCache) stagingDir() (string, error) {
+	dir := filepath.Join(c.CacheDir, DefaultStagingDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	return dir, nil
+}
+
+// stage creates a new directory in the staging directory.
+func (c *diskCache) stage(pattern string) (string, error) {
+	dir, err := c.stagingDir()
+	if err != nil {
+		return "", err
+	}
+
+	return os.MkdirTemp(dir, pattern)
+}
+
+// StagingPath returns a new path in the staging directory for the target at url to be fetched to. The path is named
+// for the lock of the target, so that Clean can tell whether it is still being fetched.
+func (c *diskCache) StagingPath(url string) (string, error) {
+	dir, err := c.stagingDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s%s-%d", stagedFetchPrefix, lockName(c.pathForTarget(fan.Target{Url: url})), rand.Int63())
+
+	return filepath.Join(dir, name), nil
+}
+
+// cleanStaging removes everything in the staging directory which was orphaned by an interrupted fetch or add. It must
+// be called with the cache lock held exclusively, so that nothing is being added or removed.
+func (c *diskCache) cleanStaging() error {
+	dir := filepath.Join(c.CacheDir, DefaultStagingDir)
+
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read staging directory: %w", err)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+
+		// targets are locked, shared or exclusively, for as long as their staged fetch is in use
+		if name, found := strings.CutPrefix(file.Name(), stagedFetchPrefix); found {
+			name, _, _ = strings.Cut(name, "-")
+
+			unlock, err := c.lock(name, true, false)
+			if errors.Is(err, errLocked) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			err = os.RemoveAll(path)
+			unlock()
+
+			if err != nil {
+				return fmt.Errorf("failed to remove staged fetch: %w", err)
+			}
+
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove staged entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// removeDir removes dir by first moving it into the staging directory, so that it disappears from the cache at once.
+func (c *diskCache) removeDir(dir string) error {
+	trash, err := c.stage("removed-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(trash)
+
+	if err := os.Rename(dir, filepath.Join(trash, filepath.Base(dir))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// replaceDir moves the directory at src to dst, replacing any directory already at dst.
+func (c *diskCache) replaceDir(src string, dst string) error {
+	// another writer may move its own directory into place between removing dst and renaming src to it
+	for attempt := 0; ; attempt++ {
+		err := os.Rename(src, dst)
+		if err == nil {
+			return nil
+		} else if attempt == 3 {
+			return err
+		}
+
+		if err := c.removeDir(dst); err != nil {
+			return err
+		}
+	}
+}
+
+// AddTarget adds the given target to the cache, using path as the on-disk executable location. The entry for the
+// target is prepared in the staging directory and then moved into place, replacing any entry already there.
 func (c *diskCache) AddTarget(target fan.Target, executable string) error {
 	path := c.pathForTarget(target)
-	executablePath := filepath.Join(path, target.ExecutableName())
-	metadataPath := filepath.Join(path, DefaultTargetMetadataFile)
 
-	if err := os.MkdirAll(path, 0o755); err != nil {
-		return fmt.Errorf("failed creating cache location for dir: %w", err)
+	// blobs are only removed while the cache lock is held exclusively, so a new blob is not removed before the entry
+	// referencing it is in place
+	unlock, err := c.lock(cacheLockName, false, true)
+	if err != nil {
+		return err
 	}
 
-	if err := os.Rename(executable, executablePath); err != nil {
+	err = c.addTarget(target, executable, path)
+	unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if c.MaxSize > 0 {
+		unlock, err := c.lock(cacheLockName, true, true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := c.evict(c.MaxSize, path); err != nil {
+			return fmt.Errorf("failed to evict targets: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *diskCache) addTarget(target fan.Target, executable string, path string) error {
+	staging, err := c.stage("entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	contentPath := filepath.Join(staging, target.ExecutableName())
+	metadataPath := filepath.Join(staging, DefaultTargetMetadataFile)
+
+	if err := move(executable, contentPath); err != nil {
 		return fmt.Errorf("failed moving target executable to cache: %w", err)
 	}
 
+	size, err := diskUsage(contentPath)
+	if err != nil {
+		return fmt.Errorf("failed to determine size of target: %w", err)
+	}
+
+	target.Size = size
+
+	if target.Blob, err = c.storeBlob(contentPath, path); err != nil {
+		return fmt.Errorf("failed to store target content: %w", err)
+	}
+
+	if err := c.writeMetadata(metadataPath, target); err != nil {
+		return err
+	}
+
+	if err := syncDir(staging); err != nil {
+		return err
+	}
+
+	if err := c.replaceDir(staging, path); err != nil {
+		return fmt.Errorf("failed to move target into cache: %w", err)
+	}
+
+	// the entry is only durable once the directory it was renamed into is synced
+	return syncDir(c.CacheDir)
+}
+
+// writeMetadata writes the metadata of a target which was just added or refreshed.
+func (c *diskCache) writeMetadata(metadataPath string, target fan.Target) error {
 	target.CachedAt = time.Now().UTC()
+	target.AccessedAt = target.CachedAt
 
+	return c.updateMetadata(metadataPath, target)
+}
+
+// updateMetadata replaces the metadata at metadataPath with that of target. The new metadata is written beside the
+// old and renamed over it, so that it is never read partially written.
+func (c *diskCache) updateMetadata(metadataPath string, target fan.Target) error {
 	out, err := yaml.Marshal(target)
 	if err != nil {
 		return fmt.Errorf("failed marshalling target metadata: %w", err)
 	}
 
-	if err := os.WriteFile(metadataPath, out, 0o644); err != nil {
+	f, err := os.CreateTemp(filepath.Dir(metadataPath), "."+DefaultTargetMetadataFile+"-*")
+	if err != nil {
+		return fmt.Errorf("failed writing target metadata to cache: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(out)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed writing target metadata to cache: %w", err)
+	}
+
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed writing target metadata to cache: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), metadataPath); err != nil {
 		return fmt.Errorf("failed writing target metadata to cache: %w", err)
 	}
 
 	return nil
 }
 
+// RefreshTarget updates the metadata of an already cached target, resetting the time it was cached at.
+func (c *diskCache) RefreshTarget(target fan.Target) error {
+	path := c.pathForTarget(target)
+
+	if exists, err := PathExists(path); err != nil {
+		return fmt.Errorf("failed checking for cached target: %w", err)
+	} else if !exists {
+		return ErrNotFound
+	}
+
+	return c.writeMetadata(filepath.Join(path, DefaultTargetMetadataFile), target)
+}
+
+// GetTargetForUrl returns the cached target for u. Expired targets are returned along with ErrExpired rather than
+// being removed, so that they can be revalidated.
 func (c *diskCache) GetTargetForUrl(u string) (fan.Target, string, error) {
 	target := fan.Target{Url: u}
 	path := c.pathForTarget(target)
@@ -83,17 +324,39 @@ func (c *diskCache) GetTargetForUrl(u string) (fan.Target, string, error) {
 		return fan.Target{}, "", fmt.Errorf("failed unmarshalling target metadata: %w", err)
 	}
 
-	invalidAfter := target.CachedAt.Add(target.InvalidateAfter)
+	// targets which share a cache entry may select different executables from it
+	target.Url = u
+	if err := target.ValidateEntrypoint(); err != nil {
+		return fan.Target{}, "", err
+	}
 
-	if time.Now().UTC().After(invalidAfter) {
-		if err := os.RemoveAll(path); err != nil && !errors.Is(err, os.ErrNotExist) {
-			return fan.Target{}, "", fmt.Errorf("unable to clean target from cache")
-		}
+	executable := filepath.Join(path, target.ExecutablePath())
 
-		return fan.Target{}, "", ErrNotFound
+	if target.IsExpired() {
+		return target, executable, ErrExpired
+	}
+
+	return target, executable, nil
+}
+
+// LockUrl waits for and takes the lock on the target for url.
+func (c *diskCache) LockUrl(url string, exclusive bool) (func() error, error) {
+	return c.lock(lockName(c.pathForTarget(fan.Target{Url: url})), exclusive, true)
+}
+
+// TouchTarget records that an already cached target was used, without changing when it expires.
+func (c *diskCache) TouchTarget(target fan.Target) error {
+	path := c.pathForTarget(target)
+
+	if exists, err := PathExists(path); err != nil {
+		return fmt.Errorf("failed checking for cached target: %w", err)
+	} else if !exists {
+		return ErrNotFound
 	}
 
-	return target, filepath.Join(path, target.ExecutableName()), nil
+	target.AccessedAt = time.Now().UTC()
+
+	return c.updateMetadata(filepath.Join(path, DefaultTargetMetadataFile), target)
 }
 
 func (c *diskCache) InvalidateUrl(url string) error {
@@ -101,35 +364,54 @@ func (c *diskCache) InvalidateUrl(url string) error {
 		Url: url,
 	})
 
-	if err := os.RemoveAll(path); err != nil {
+	unlock, err := c.lock(cacheLockName, false, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := c.removeDir(path); err != nil {
 		return fmt.Errorf("could not remove target from disck: %w", err)
 	}
 
 	return nil
 }
 
-func (c *diskCache) cleanTargetDir(dir string) error {
-	metadataPath := filepath.Join(dir, DefaultTargetMetadataFile)
-
+func readMetadata(dir string) (fan.Target, error) {
 	var target fan.Target
-	data, err := os.ReadFile(metadataPath)
+
+	data, err := os.ReadFile(filepath.Join(dir, DefaultTargetMetadataFile))
 	if err != nil {
-		return fmt.Errorf("failed reading target metadata: %w", err)
+		return fan.Target{}, fmt.Errorf("failed reading target metadata: %w", err)
 	}
 
 	if err := yaml.Unmarshal(data, &target); err != nil {
-		return fmt.Errorf("failed unmarshalling target metadata: %w", err)
+		return fan.Target{}, fmt.Errorf("failed unmarshalling target metadata: %w", err)
 	}
 
-	invalidAfter := target.CachedAt.Add(target.InvalidateAfter)
+	return target, nil
+}
 
-	if time.Now().UTC().After(invalidAfter) {
-		if err := os.RemoveAll(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("unable to clean target from cache")
-		}
+// cleanTargetDir removes the target at dir if it has expired, unless it is locked for being fetched again. Targets
+// whose metadata cannot be read, like those left by an interrupted write, can never be used and are removed as well.
+func (c *diskCache) cleanTargetDir(dir string) error {
+	if target, err := readMetadata(dir); err == nil && !target.IsExpired() {
+		return nil
 	}
 
-	return nil
+	unlock, err := c.lock(lockName(dir), true, false)
+	if errors.Is(err, errLocked) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := c.removeDir(dir); err != nil {
+		return fmt.Errorf("unable to clean target from cache: %w", err)
+	}
+
+	return c.removeLock(lockName(dir))
 }
 
 func (c *diskCache) Clean() error {
@@ -138,8 +420,18 @@ func (c *diskCache) Clean() error {
 		return fmt.Errorf("failed to read cache directory: %w", err)
 	}
 
+	unlock, err := c.lock(cacheLockName, true, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := c.cleanStaging(); err != nil {
+		return err
+	}
+
 	for _, file := range files {
-		if file.IsDir() {
+		if file.IsDir() && isEntryDir(file.Name()) {
 			targetPath := filepath.Join(c.CacheDir, file.Name())
 
 			if err := c.cleanTargetDir(targetPath); err != nil {
@@ -148,5 +440,207 @@ func (c *diskCache) Clean() error {
 		}
 	}
 
+	if err := c.cleanLocks(); err != nil {
+		return err
+	}
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	if err := c.removeUnreferencedBlobs(references(entries)); err != nil {
+		return err
+	}
+
+	if c.MaxSize > 0 {
+		return c.evict(c.MaxSize, "")
+	}
+
 	return nil
 }
+
+// entry is a target in the cache and the directory it is stored in.
+type entry struct {
+	dir    string
+	target fan.Target
+	size   int64
+}
+
+// lastUsed returns when the target was last used, targets cached before access times were recorded were last used
+// when they were cached.
+func (e entry) lastUsed() time.Time {
+	if e.target.AccessedAt.IsZero() {
+		return e.target.CachedAt
+	}
+
+	return e.target.AccessedAt
+}
+
+// entries returns every target in the cache whose metadata can be read.
+func (c *diskCache) entries() ([]entry, error) {
+	files, err := os.ReadDir(c.CacheDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var entries []entry
+
+	for _, file := range files {
+		if !file.IsDir() || !isEntryDir(file.Name()) {
+			continue
+		}
+
+		dir := filepath.Join(c.CacheDir, file.Name())
+
+		// unreadable targets are removed by Clean, and must not prevent evicting the others
+		target, err := readMetadata(dir)
+		if err != nil {
+			continue
+		}
+
+		// targets cached before their size was recorded are measured like they would be when added
+		size := target.Size
+		if size == 0 {
+			if size, err = diskUsage(filepath.Join(dir, target.ExecutableName())); err != nil {
+				return nil, fmt.Errorf("failed to determine size of target: %w", err)
+			}
+		}
+
+		entries = append(entries, entry{
+			dir:    dir,
+			target: target,
+			size:   size,
+		})
+	}
+
+	return entries, nil
+}
+
+// Evict removes the least recently used targets until the cache is no larger than maxSize.
+func (c *diskCache) Evict(maxSize fan.ByteSize) error {
+	unlock, err := c.lock(cacheLockName, true, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.evict(maxSize, "")
+}
+
+// references counts the entries referencing each blob.
+func references(entries []entry) map[string]int {
+	refs := make(map[string]int)
+
+	for _, e := range entries {
+		if e.target.Blob != "" {
+			refs[e.target.Blob]++
+		}
+	}
+
+	return refs
+}
+
+// evict removes the least recently used targets other than the one stored at keep until the cache is no larger than
+// maxSize. Content shared by several targets only counts towards the size of the cache once, and is only removed
+// along with the last target referencing it. Targets which are locked, as they are while being fetched, are never
+// removed.
+func (c *diskCache) evict(maxSize fan.ByteSize, keep string) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	refs := references(entries)
+
+	var total int64
+	counted := make(map[string]bool)
+
+	for _, e := range entries {
+		if e.target.Blob != "" {
+			if counted[e.target.Blob] {
+				continue
+			}
+
+			counted[e.target.Blob] = true
+		}
+
+		total += e.size
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed().Before(entries[j].lastUsed())
+	})
+
+	for _, e := range entries {
+		if total <= int64(maxSize) {
+			break
+		}
+
+		if e.dir == keep {
+			continue
+		}
+
+		unlock, err := c.lock(lockName(e.dir), true, false)
+		if errors.Is(err, errLocked) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = c.removeDir(e.dir)
+		if err == nil {
+			err = c.removeLock(lockName(e.dir))
+		}
+		unlock()
+
+		if err != nil {
+			return fmt.Errorf("failed to evict target '%s': %w", e.target.Url, err)
+		}
+
+		if e.target.Blob == "" {
+			total -= e.size
+			continue
+		}
+
+		if refs[e.target.Blob]--; refs[e.target.Blob] > 0 {
+			continue
+		}
+
+		if err := os.RemoveAll(c.blobPath(e.target.Blob)); err != nil {
+			return fmt.Errorf("failed to evict blob of target '%s': %w", e.target.Url, err)
+		}
+
+		total -= e.size
+	}
+
+	return nil
+}
+
+// diskUsage returns the total size of the regular files at path.
+func diskUsage(path string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
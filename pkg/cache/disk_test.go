@@ -47,32 +47,292 @@ func TestAddTarget(t *testing.T) {
 		target, executable, err := cache.GetTargetForUrl(target.Url)
 
 		assert.WithinDuration(t, time.Now(), target.CachedAt, time.Second*1)
+		assert.Equal(t, target.CachedAt, target.AccessedAt)
 		target.CachedAt = time.Time{}
+		target.AccessedAt = time.Time{}
 
 		assert.Equal(t, fan.Target{
 			Url:             "https://example.com",
 			InvalidateAfter: time.Hour * 1,
+			Blob:            "cc519621a19f30a822a068594609e3169634b6a42b455348916f4f460c01a7b0",
 		}, target)
 		assert.Equal(t, filepath.Join(cacheDir, fmt.Sprint(target.Hash()), "example.com"), executable)
 		assert.NoError(t, err)
 	})
 
-	t.Run("FailsToAddDuplicateTarget", func(t *testing.T) {
-
-		f, err := os.CreateTemp("", strings.Replace(t.Name()+"-executable-*", "/", "-", -1))
-		if err != nil {
-			t.Fatalf("failed to create file: %s", err)
-		}
-
+	t.Run("ReplacesDuplicateTarget", func(t *testing.T) {
 		target := fan.Target{
 			Url:             "https://example.com",
 			InvalidateAfter: time.Hour * 1,
 		}
 
-		err = cache.AddTarget(target, f.Name())
+		for _, content := range []string{"first", "second"} {
+			executable := filepath.Join(t.TempDir(), "executable")
+			if err := os.WriteFile(executable, []byte(content), 0o755); err != nil {
+				t.Fatalf("failed to write executable: %s", err)
+			}
+
+			assert.NoError(t, cache.AddTarget(target, executable))
+		}
+
+		_, executable, err := cache.GetTargetForUrl(target.Url)
 		assert.NoError(t, err)
 
-		err = cache.AddTarget(target, f.Name())
-		assert.Error(t, err)
+		data, err := os.ReadFile(executable)
+		assert.NoError(t, err)
+		assert.Equal(t, "second", string(data))
+	})
+}
+
+func TestEvict(t *testing.T) {
+	cacheDir := t.TempDir()
+	c := cache.NewDiskCacheWithMaxSize(cacheDir, 10)
+
+	add := func(u string) {
+		executable := filepath.Join(t.TempDir(), "executable")
+		if err := os.WriteFile(executable, []byte(u[len(u)-4:]), 0o755); err != nil {
+			t.Fatalf("failed to write executable: %s", err)
+		}
+
+		assert.NoError(t, c.AddTarget(fan.Target{Url: u, InvalidateAfter: time.Hour}, executable))
+	}
+
+	add("https://example.com/a")
+	add("https://example.com/b")
+
+	a, _, err := c.GetTargetForUrl("https://example.com/a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), a.Size)
+	assert.NoError(t, c.TouchTarget(a))
+
+	add("https://example.com/c")
+
+	for u, expected := range map[string]error{
+		"https://example.com/a": nil,
+		"https://example.com/b": cache.ErrNotFound,
+		"https://example.com/c": nil,
+	} {
+		_, _, err := c.GetTargetForUrl(u)
+		assert.ErrorIs(t, err, expected, u)
+	}
+
+	assert.NoError(t, c.Evict(4))
+
+	_, _, err = c.GetTargetForUrl("https://example.com/a")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	_, _, err = c.GetTargetForUrl("https://example.com/c")
+	assert.NoError(t, err)
+}
+
+func TestUnreadableEntry(t *testing.T) {
+	cacheDir := t.TempDir()
+	c := cache.NewDiskCacheWithMaxSize(cacheDir, 10)
+
+	missing := filepath.Join(cacheDir, "1")
+	assert.NoError(t, os.MkdirAll(missing, 0o755))
+
+	corrupt := filepath.Join(cacheDir, "2")
+	assert.NoError(t, os.MkdirAll(corrupt, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(corrupt, cache.DefaultTargetMetadataFile), []byte("{"), 0o644))
+
+	for _, u := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		executable := filepath.Join(t.TempDir(), "executable")
+		assert.NoError(t, os.WriteFile(executable, []byte(u[len(u)-4:]), 0o755))
+		assert.NoError(t, c.AddTarget(fan.Target{Url: u, InvalidateAfter: time.Hour}, executable))
+	}
+
+	_, _, err := c.GetTargetForUrl("https://example.com/a")
+	assert.ErrorIs(t, err, cache.ErrNotFound)
+
+	assert.NoError(t, c.Clean())
+
+	for _, dir := range []string{missing, corrupt} {
+		exists, err := cache.PathExists(dir)
+		assert.NoError(t, err)
+		assert.False(t, exists, dir)
+	}
+
+	_, _, err = c.GetTargetForUrl("https://example.com/c")
+	assert.NoError(t, err)
+}
+
+func TestCleanLocks(t *testing.T) {
+	cacheDir := t.TempDir()
+	c := cache.NewDiskCacheWithMaxSize(cacheDir, 4)
+
+	locks := func() []string {
+		entries, _ := os.ReadDir(filepath.Join(cacheDir, cache.DefaultLocksDir))
+
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+
+		return names
+	}
+
+	for _, u := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		unlock, err := c.LockUrl(u, true)
+		assert.NoError(t, err)
+
+		executable := filepath.Join(t.TempDir(), "executable")
+		assert.NoError(t, os.WriteFile(executable, []byte(u[len(u)-4:]), 0o755))
+		assert.NoError(t, c.AddTarget(fan.Target{Url: u, InvalidateAfter: time.Hour}, executable))
+
+		assert.NoError(t, unlock())
+	}
+
+	// evicted targets leave only the locks of the remaining target and the cache
+	assert.Len(t, locks(), 2)
+
+	assert.NoError(t, c.InvalidateUrl("https://example.com/c"))
+	assert.NoError(t, c.Clean())
+	assert.Equal(t, []string{"cache.lock"}, locks())
+}
+
+func TestBlobs(t *testing.T) {
+	cacheDir := t.TempDir()
+	c := cache.NewDiskCache(cacheDir)
+
+	add := func(u string, content string) {
+		executable := filepath.Join(t.TempDir(), "executable")
+		if err := os.WriteFile(executable, []byte(content), 0o755); err != nil {
+			t.Fatalf("failed to write executable: %s", err)
+		}
+
+		assert.NoError(t, c.AddTarget(fan.Target{Url: u, InvalidateAfter: time.Hour}, executable))
+	}
+
+	blobs := func() []string {
+		entries, _ := os.ReadDir(filepath.Join(cacheDir, cache.DefaultBlobsDir, "sha256"))
+
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+
+		return names
+	}
+
+	add("https://example.com/a", "echo hello")
+	add("https://mirror.example.com/a", "echo hello")
+
+	a, executable, err := c.GetTargetForUrl("https://example.com/a")
+	assert.NoError(t, err)
+
+	mirror, mirrorExecutable, err := c.GetTargetForUrl("https://mirror.example.com/a")
+	assert.NoError(t, err)
+
+	assert.Equal(t, a.Blob, mirror.Blob)
+	assert.Equal(t, []string{a.Blob}, blobs())
+
+	for _, path := range []string{executable, mirrorExecutable} {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "echo hello", string(data))
+	}
+
+	assert.NoError(t, c.InvalidateUrl("https://example.com/a"))
+	assert.NoError(t, c.Clean())
+	assert.Equal(t, []string{a.Blob}, blobs())
+
+	assert.NoError(t, c.InvalidateUrl("https://mirror.example.com/a"))
+	assert.NoError(t, c.Clean())
+	assert.Empty(t, blobs())
+
+	t.Run("Mode", func(t *testing.T) {
+		for u, mode := range map[string]os.FileMode{"https://example.com/exec": 0o755, "https://example.com/data": 0o644} {
+			executable := filepath.Join(t.TempDir(), "executable")
+			assert.NoError(t, os.WriteFile(executable, []byte("echo mode"), mode))
+			assert.NoError(t, c.AddTarget(fan.Target{Url: u, InvalidateAfter: time.Hour}, executable))
+		}
+
+		exec, executable, err := c.GetTargetForUrl("https://example.com/exec")
+		assert.NoError(t, err)
+
+		data, _, err := c.GetTargetForUrl("https://example.com/data")
+		assert.NoError(t, err)
+
+		assert.NotEqual(t, exec.Blob, data.Blob)
+
+		info, err := os.Stat(executable)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+	})
+
+	t.Run("GitDir", func(t *testing.T) {
+		for u, head := range map[string]string{"git+https://example.com/a.git//run.sh": "a", "git+https://mirror.example.com/a.git//run.sh": "b"} {
+			checkout := filepath.Join(t.TempDir(), "checkout")
+			assert.NoError(t, os.MkdirAll(filepath.Join(checkout, ".git"), 0o755))
+			assert.NoError(t, os.WriteFile(filepath.Join(checkout, ".git", "FETCH_HEAD"), []byte(head), 0o644))
+			assert.NoError(t, os.WriteFile(filepath.Join(checkout, "run.sh"), []byte("echo git"), 0o755))
+			assert.NoError(t, c.AddTarget(fan.Target{Url: u, InvalidateAfter: time.Hour}, checkout))
+		}
+
+		a, _, err := c.GetTargetForUrl("git+https://example.com/a.git//run.sh")
+		assert.NoError(t, err)
+
+		mirror, _, err := c.GetTargetForUrl("git+https://mirror.example.com/a.git//run.sh")
+		assert.NoError(t, err)
+
+		assert.Equal(t, a.Blob, mirror.Blob)
+	})
+}
+
+func TestStaging(t *testing.T) {
+	cacheDir := t.TempDir()
+	c := cache.NewDiskCache(cacheDir)
+
+	stagingDir := filepath.Join(cacheDir, cache.DefaultStagingDir)
+
+	staged := func(path string) bool {
+		exists, err := cache.PathExists(path)
+		assert.NoError(t, err)
+		return exists
+	}
+
+	t.Run("AddStagedTarget", func(t *testing.T) {
+		path, err := c.StagingPath("https://example.com/staged")
+		assert.NoError(t, err)
+		assert.Equal(t, stagingDir, filepath.Dir(path))
+
+		assert.NoError(t, os.WriteFile(path, []byte("echo staged"), 0o755))
+		assert.NoError(t, c.AddTarget(fan.Target{Url: "https://example.com/staged", InvalidateAfter: time.Hour}, path))
+
+		_, executable, err := c.GetTargetForUrl("https://example.com/staged")
+		assert.NoError(t, err)
+
+		data, err := os.ReadFile(executable)
+		assert.NoError(t, err)
+		assert.Equal(t, "echo staged", string(data))
+
+		assert.False(t, staged(path))
+	})
+
+	t.Run("CleanOrphanedStaging", func(t *testing.T) {
+		unlock, err := c.LockUrl("https://example.com/fetching", true)
+		assert.NoError(t, err)
+
+		fetching, err := c.StagingPath("https://example.com/fetching")
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(fetching, []byte("echo fetching"), 0o755))
+
+		orphaned, err := c.StagingPath("https://example.com/orphaned")
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(orphaned, []byte("echo orphaned"), 0o755))
+
+		entry := filepath.Join(stagingDir, "entry-0")
+		assert.NoError(t, os.Mkdir(entry, 0o755))
+
+		assert.NoError(t, c.Clean())
+		assert.True(t, staged(fetching))
+		assert.False(t, staged(orphaned))
+		assert.False(t, staged(entry))
+
+		assert.NoError(t, unlock())
+
+		assert.NoError(t, c.Clean())
+		assert.False(t, staged(fetching))
 	})
 }
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	fan "github.com/joshmeranda/fan/pkg"
+)
+
+var (
+	// ErrChangeRejected is returned when the new content of a previously cached target is not accepted.
+	ErrChangeRejected = errors.New("target content changed")
+
+	// ErrConflict is returned when the cached target or its fetched replacement changed while waiting for the
+	// replacement to be confirmed. Fetching the target again may succeed.
+	ErrConflict = errors.New("target changed while awaiting confirmation")
+)
+
+// ConfirmChange is called with the previously cached target and executable when a target is fetched again and its
+// executable has changed. Returning an error refuses the new content, leaving the previous target in the cache.
+type ConfirmChange func(previous fan.Target, previousExecutable string, current fan.Target, executable string) error
+
+// isStale reports whether fetcher considers the cached target out of date with its source, or if the target is now
+// pinned to a different digest than the one it was fetched with.
+func isStale(fetcher fan.Fetcher, cached fan.Target) (bool, error) {
+	if cached.PinChanged() {
+		return true, nil
+	}
+
+	validator, ok := fetcher.(fan.Validator)
+	if !ok {
+		return false, nil
+	}
+
+	return validator.IsStale(cached)
+}
+
+// resolve returns the url the target at raw is cached under, which for fan.Resolver fetchers is the url of the
+// content it currently refers to.
+func resolve(fetcher fan.Fetcher, raw string) (string, error) {
+	resolver, ok := fetcher.(fan.Resolver)
+	if !ok {
+		return raw, nil
+	}
+
+	return resolver.Resolve(raw)
+}
+
+// verified returns the cached target and executable if the executable still matches its digest.
+func verified(cached fan.Target, executable string) (fan.Target, string, error) {
+	if _, err := fan.VerifyExecutable(cached, executable); err != nil {
+		return fan.Target{}, "", fmt.Errorf("failed to verify cached executable: %w", err)
+	}
+
+	return cached, executable, nil
+}
+
+// lookupTarget returns the cached target for url, and whether it can be used without fetching it again. Targets which
+// are not cached or have expired are returned with ErrNotFound or ErrExpired.
+func lookupTarget(c Cache, fetcher fan.Fetcher, url string) (fan.Target, string, bool, error) {
+	cached, executable, err := c.GetTargetForUrl(url)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrExpired) {
+		return cached, executable, false, err
+	} else if err != nil {
+		return fan.Target{}, "", false, fmt.Errorf("failed to get target from cache: %w", err)
+	}
+
+	stale, err := isStale(fetcher, cached)
+	if err != nil {
+		return fan.Target{}, "", false, fmt.Errorf("failed to validate cached target: %w", err)
+	}
+
+	return cached, executable, !stale, nil
+}
+
+// unchanged reports whether the cached target for url is still the one which was previously cached, and whether the
+// fetched target staged at path has not been cleaned up.
+func unchanged(c Cache, url string, previous fan.Target, path string) (bool, error) {
+	staged, err := PathExists(path)
+	if err != nil || !staged {
+		return false, err
+	}
+
+	cached, _, err := c.GetTargetForUrl(url)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	} else if err != nil && !errors.Is(err, ErrExpired) {
+		return false, err
+	}
+
+	return cached.CachedAt.Equal(previous.CachedAt) && cached.Sha256 == previous.Sha256, nil
+}
+
+// use records that the cached target was used and returns it once it is verified.
+func use(c Cache, cached fan.Target, executable string) (fan.Target, string, error) {
+	// access times only inform eviction, so failing to record one does not prevent running the target
+	_ = c.TouchTarget(cached)
+
+	return verified(cached, executable)
+}
+
+// FetchTarget returns the cached target and executable path for target.Url. If the target is not yet cached, or if
+// fetcher is a fan.Validator which reports the cached target as stale, it is retrieved with fetcher and added to the
+// cache. Expired targets are revalidated with any validators recorded on the cached target, so that an unmodified
+// target is only refreshed rather than fetched again. Cached executables are verified against their recorded digest
+// before being returned.
+//
+// Targets whose fetcher is a fan.Resolver are cached under their resolved url, so that urls which refer to the same
+// content share a cache entry and a url which is changed to refer to new content is fetched again.
+//
+// Cached targets are used while holding a shared lock on the target, so concurrent calls for the same cached target do
+// not wait on each other. The lock is only taken exclusively while the target is fetched, so concurrent calls for the
+// same target fetch it only once.
+//
+// If confirm is not nil, it is called before a changed executable replaces a previously cached one. Only a shared lock
+// is held while waiting for confirmation, and ErrConflict is returned if the target was changed or its fetched
+// replacement cleaned up before the lock could be taken exclusively again.
+func FetchTarget(c Cache, fetcher fan.Fetcher, target fan.Target, confirm ConfirmChange) (fan.Target, string, error) {
+	resolved, err := resolve(fetcher, target.Url)
+	if err != nil {
+		return fan.Target{}, "", fmt.Errorf("failed to resolve target: %w", err)
+	}
+	target.Url = resolved
+
+	unlock, err := c.LockUrl(target.Url, false)
+	if err != nil {
+		return fan.Target{}, "", fmt.Errorf("failed to lock target: %w", err)
+	}
+	defer func() {
+		unlock()
+	}()
+
+	cached, executable, fresh, err := lookupTarget(c, fetcher, target.Url)
+	if err == nil && fresh {
+		return use(c, cached, executable)
+	} else if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrExpired) {
+		return fan.Target{}, "", err
+	}
+
+	// relock relocks the target, the lock is released first since locks cannot be converted atomically
+	relock := func(exclusive bool) error {
+		unlock()
+
+		var err error
+		if unlock, err = c.LockUrl(target.Url, exclusive); err != nil {
+			unlock = func() error { return nil }
+			return fmt.Errorf("failed to lock target: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := relock(true); err != nil {
+		return fan.Target{}, "", err
+	}
+
+	// the target may have been fetched by someone else while waiting for the lock
+	cached, executable, fresh, err = lookupTarget(c, fetcher, target.Url)
+	switch {
+	case err == nil && fresh:
+		return use(c, cached, executable)
+	case errors.Is(err, ErrExpired):
+		if !cached.NoStore {
+			target.ETag = cached.ETag
+			target.LastModified = cached.LastModified
+		}
+	case err == nil, errors.Is(err, ErrNotFound):
+	default:
+		return fan.Target{}, "", err
+	}
+
+	tmpExecutable, err := c.StagingPath(target.Url)
+	if err != nil {
+		return fan.Target{}, "", fmt.Errorf("failed to stage target: %w", err)
+	}
+	defer os.RemoveAll(tmpExecutable)
+
+	err = fetcher.Fetch(&target, tmpExecutable)
+	if errors.Is(err, fan.ErrNotModified) {
+		cached.InvalidateAfter = target.InvalidateAfter
+
+		if err := c.RefreshTarget(cached); err != nil {
+			return fan.Target{}, "", fmt.Errorf("failed to refresh target in cache: %w", err)
+		}
+
+		return verified(cached, executable)
+	} else if err != nil {
+		return fan.Target{}, "", fmt.Errorf("failed to fetch executable for target: %w", err)
+	}
+
+	// a changed pin already states which content is expected, so there is nothing to confirm
+	if confirm != nil && executable != "" && !cached.PinChanged() && target.ContentChanged(cached) {
+		// other uses of the cached target need not wait for an answer, but the staged target must not be cleaned
+		if err := relock(false); err != nil {
+			return fan.Target{}, "", err
+		}
+
+		if err := confirm(cached, executable, target, filepath.Join(tmpExecutable, target.EntrypointPath())); err != nil {
+			return fan.Target{}, "", fmt.Errorf("%w: %w", ErrChangeRejected, err)
+		}
+
+		if err := relock(true); err != nil {
+			return fan.Target{}, "", err
+		}
+
+		// nothing was locked while the lock was changed, so the staged target may have been cleaned or the cached
+		// target replaced
+		if ok, err := unchanged(c, target.Url, cached, tmpExecutable); err != nil {
+			return fan.Target{}, "", fmt.Errorf("failed to check cached target: %w", err)
+		} else if !ok {
+			return fan.Target{}, "", ErrConflict
+		}
+	}
+
+	if err := c.InvalidateUrl(target.Url); err != nil {
+		return fan.Target{}, "", fmt.Errorf("failed to invalidate previous target: %w", err)
+	}
+
+	if err := c.AddTarget(target, tmpExecutable); err != nil {
+		return fan.Target{}, "", fmt.Errorf("failed to add the target to the cache: %w", err)
+	}
+
+	// targets which may not be stored are added so they can be run, but are expired immediately
+	if cached, executable, err = c.GetTargetForUrl(target.Url); err != nil && !errors.Is(err, ErrExpired) {
+		return fan.Target{}, "", fmt.Errorf("failed to get new target from cache: %w", err)
+	}
+
+	return cached, executable, nil
+}
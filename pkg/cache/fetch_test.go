@@ -0,0 +1,303 @@
+package cache_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchTargetRevalidation(t *testing.T) {
+	downloads := 0
+	etag := `"v1"`
+	cacheControl := ""
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		downloads++
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, "#!/usr/bin/env bash\nexit 0")
+	}))
+	defer server.Close()
+
+	c := cache.NewDiskCache(t.TempDir())
+	target := fan.Target{
+		Url:             server.URL + "/script",
+		InvalidateAfter: time.Millisecond,
+	}
+
+	t.Run("Fetches", func(t *testing.T) {
+		cached, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, etag, cached.ETag)
+		assert.Equal(t, 1, downloads)
+	})
+
+	t.Run("NotModified", func(t *testing.T) {
+		time.Sleep(time.Millisecond * 5)
+
+		_, _, err := c.GetTargetForUrl(target.Url)
+		assert.ErrorIs(t, err, cache.ErrExpired)
+
+		_, executable, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+		assert.NoError(t, err)
+		assert.FileExists(t, executable)
+		assert.Equal(t, 1, downloads)
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		time.Sleep(time.Millisecond * 5)
+		etag = `"v2"`
+
+		cached, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, etag, cached.ETag)
+		assert.Equal(t, 2, downloads)
+	})
+
+	t.Run("MaxAge", func(t *testing.T) {
+		time.Sleep(time.Millisecond * 5)
+		cacheControl = "public, max-age=3600"
+
+		cached, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Hour, cached.InvalidateAfter)
+
+		_, _, err = c.GetTargetForUrl(target.Url)
+		assert.NoError(t, err)
+	})
+
+	t.Run("NoStore", func(t *testing.T) {
+		cacheControl = "no-store"
+		assert.NoError(t, c.InvalidateUrl(target.Url))
+
+		cached, executable, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+		assert.NoError(t, err)
+		assert.True(t, cached.NoStore)
+		assert.FileExists(t, executable)
+
+		_, _, err = c.GetTargetForUrl(target.Url)
+		assert.ErrorIs(t, err, cache.ErrExpired)
+	})
+}
+
+func TestFetchTargetChecksum(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/usr/bin/env bash\nexit 0"), 0o755))
+
+	digest, err := fan.Sha256File(script)
+	assert.NoError(t, err)
+
+	c := cache.NewDiskCache(filepath.Join(dir, "cache"))
+
+	t.Run("Mismatch", func(t *testing.T) {
+		u, err := fan.WithSha256("file://"+script, strings.Repeat("0", 64))
+		assert.NoError(t, err)
+
+		_, _, err = cache.FetchTarget(c, fan.DefaultRegistry, fan.Target{Url: u}, nil)
+		assert.ErrorIs(t, err, fan.ErrChecksumMismatch)
+
+		_, _, err = c.GetTargetForUrl(u)
+		assert.ErrorIs(t, err, cache.ErrNotFound)
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		u, err := fan.WithSha256("file://"+script, "sha256:"+digest)
+		assert.NoError(t, err)
+
+		cached, _, err := cache.FetchTarget(c, fan.DefaultRegistry, fan.Target{Url: u}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, digest, cached.Sha256)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		_, executable, err := c.GetTargetForUrl("file://" + script)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(executable, []byte("#!/usr/bin/env bash\nexit 1"), 0o755))
+
+		_, _, err = cache.FetchTarget(c, fan.DefaultRegistry, fan.Target{Url: "file://" + script}, nil)
+		assert.ErrorIs(t, err, fan.ErrChecksumMismatch)
+	})
+}
+
+func TestFetchTargetChanged(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/usr/bin/env bash\nexit 0"), 0o755))
+
+	c := cache.NewDiskCache(filepath.Join(dir, "cache"))
+	target := fan.Target{Url: "file://" + script}
+
+	confirmed := 0
+	reject := func(fan.Target, string, fan.Target, string) error {
+		confirmed++
+		return fmt.Errorf("rejected")
+	}
+
+	_, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, reject)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, confirmed)
+
+	updated := []byte("#!/usr/bin/env bash\nexit 1")
+	assert.NoError(t, os.WriteFile(script, updated, 0o755))
+	assert.NoError(t, os.Chtimes(script, time.Now(), time.Now().Add(time.Minute)))
+
+	t.Run("Rejected", func(t *testing.T) {
+		_, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, reject)
+		assert.ErrorIs(t, err, cache.ErrChangeRejected)
+		assert.Equal(t, 1, confirmed)
+
+		_, executable, err := c.GetTargetForUrl(target.Url)
+		assert.NoError(t, err)
+
+		data, err := os.ReadFile(executable)
+		assert.NoError(t, err)
+		assert.NotEqual(t, updated, data)
+	})
+
+	t.Run("StagingCleaned", func(t *testing.T) {
+		_, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, func(_ fan.Target, _ string, _ fan.Target, executable string) error {
+			// the staged target is not cleaned while confirming, but may be before the target is locked again
+			assert.NoError(t, c.Clean())
+
+			exists, err := cache.PathExists(executable)
+			assert.NoError(t, err)
+			assert.True(t, exists)
+
+			return os.RemoveAll(executable)
+		})
+		assert.ErrorIs(t, err, cache.ErrConflict)
+
+		_, executable, err := c.GetTargetForUrl(target.Url)
+		assert.NoError(t, err)
+
+		data, err := os.ReadFile(executable)
+		assert.NoError(t, err)
+		assert.NotEqual(t, updated, data)
+	})
+
+	t.Run("ReplacedWhileConfirming", func(t *testing.T) {
+		replacement := []byte("#!/usr/bin/env bash\nexit 2")
+
+		_, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, func(previous fan.Target, _ string, _ fan.Target, _ string) error {
+			staged, err := c.StagingPath(target.Url)
+			assert.NoError(t, err)
+			assert.NoError(t, os.WriteFile(staged, replacement, 0o755))
+
+			assert.NoError(t, c.InvalidateUrl(target.Url))
+			return c.AddTarget(previous, staged)
+		})
+		assert.ErrorIs(t, err, cache.ErrConflict)
+
+		_, executable, err := c.GetTargetForUrl(target.Url)
+		assert.NoError(t, err)
+
+		data, err := os.ReadFile(executable)
+		assert.NoError(t, err)
+		assert.Equal(t, replacement, data)
+	})
+
+	t.Run("Accepted", func(t *testing.T) {
+		_, executable, err := cache.FetchTarget(c, fan.DefaultRegistry, target, func(fan.Target, string, fan.Target, string) error {
+			return nil
+		})
+		assert.NoError(t, err)
+
+		data, err := os.ReadFile(executable)
+		assert.NoError(t, err)
+		assert.Equal(t, updated, data)
+	})
+}
+
+func TestFetchTargetConcurrently(t *testing.T) {
+	var downloads atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		time.Sleep(time.Millisecond * 50)
+		io.WriteString(w, "#!/usr/bin/env bash\nexit 0")
+	}))
+	defer server.Close()
+
+	c := cache.NewDiskCache(t.TempDir())
+	target := fan.Target{
+		Url:             server.URL + "/script",
+		InvalidateAfter: time.Hour,
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+
+	for i := range errs {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			_, executable, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+			if err == nil {
+				_, err = os.Stat(executable)
+			}
+
+			errs[i] = err
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, int32(1), downloads.Load())
+}
+
+func TestFetchTargetShared(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/usr/bin/env bash\nexit 0"), 0o755))
+
+	c := cache.NewDiskCache(filepath.Join(dir, "cache"))
+	target := fan.Target{Url: "file://" + script, InvalidateAfter: time.Hour}
+
+	_, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+	assert.NoError(t, err)
+
+	// a cached target can be used while someone else is using it
+	unlock, err := c.LockUrl(target.Url, false)
+	assert.NoError(t, err)
+	defer unlock()
+
+	done := make(chan error)
+	go func() {
+		_, _, err := cache.FetchTarget(c, fan.DefaultRegistry, target, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatalf("cached target waited for shared lock")
+	}
+}
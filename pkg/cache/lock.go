@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultLocksDir is the directory within the cache holding the files targets are locked with.
+	DefaultLocksDir = "locks"
+
+	// cacheLockName is the lock held exclusively while removing content which may be shared between targets, and
+	// shared while adding it.
+	cacheLockName = "cache"
+)
+
+// errLocked is returned when a lock which was not waited for is held by someone else.
+var errLocked = errors.New("locked")
+
+func (c *diskCache) lockPath(name string) string {
+	return filepath.Join(c.CacheDir, DefaultLocksDir, name+".lock")
+}
+
+// lock acquires the advisory lock called name, waiting for it to be released by any other holder if wait is set. Lock
+// files may be removed by whoever holds them exclusively, so a lock taken on a file which was removed while waiting
+// for it is taken again on the file which replaced it.
+func (c *diskCache) lock(name string, exclusive bool, wait bool) (func() error, error) {
+	// there is nothing to hold without advisory locks, so no lock files are created
+	if !LockingSupported {
+		return func() error { return nil }, nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(c.CacheDir, DefaultLocksDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create locks directory: %w", err)
+	}
+
+	path := c.lockPath(name)
+
+	for {
+		f, err := lockFile(path, exclusive, wait)
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := isCurrent(f, path)
+		if err == nil && current {
+			return func() error {
+				return unlockFile(f)
+			}, nil
+		}
+
+		unlockFile(f)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isCurrent reports whether the locked file f is still the lock file at path.
+func isCurrent(f *os.File, path string) (bool, error) {
+	locked, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+
+	return os.SameFile(locked, info), nil
+}
+
+// removeLock removes the lock file called name. It must only be called while holding the lock exclusively, so that
+// no one else holds a lock on the removed file.
+func (c *diskCache) removeLock(name string) error {
+	if err := os.Remove(c.lockPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+
+	return nil
+}
+
+// cleanLocks removes the lock files of targets which are no longer cached. It must be called with the cache lock held
+// exclusively, so that no target is being added.
+func (c *diskCache) cleanLocks() error {
+	files, err := os.ReadDir(filepath.Join(c.CacheDir, DefaultLocksDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read locks directory: %w", err)
+	}
+
+	for _, file := range files {
+		name, found := strings.CutSuffix(file.Name(), ".lock")
+		if !found || name == cacheLockName {
+			continue
+		}
+
+		if exists, err := PathExists(filepath.Join(c.CacheDir, name)); err != nil {
+			return fmt.Errorf("failed checking for cached target: %w", err)
+		} else if exists {
+			continue
+		}
+
+		// targets which are being fetched are locked until they are added
+		unlock, err := c.lock(name, true, false)
+		if errors.Is(err, errLocked) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = c.removeLock(name)
+		unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockName returns the name of the lock for the target cached at dir.
+func lockName(dir string) string {
+	return filepath.Base(dir)
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package cache
+
+import (
+	"errors"
+	"os"
+)
+
+// LockingSupported reports whether targets are locked while they are fetched and used, making the cache safe to share
+// between processes.
+const LockingSupported = false
+
+// lockFile is never called, since advisory locks are not supported on this platform and nothing is locked.
+func lockFile(path string, exclusive bool, wait bool) (*os.File, error) {
+	return nil, errors.New("file locks are not supported on this platform")
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// LockingSupported reports whether targets are locked while they are fetched and used, making the cache safe to share
+// between processes.
+const LockingSupported = true
+
+func lockFile(path string, exclusive bool, wait bool) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		if err = syscall.Flock(int(f.Fd()), how); !errors.Is(err, syscall.EINTR) {
+			break
+		}
+	}
+
+	if err != nil {
+		f.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+
+		return nil, fmt.Errorf("failed to lock '%s': %w", path, err)
+	}
+
+	return f, nil
+}
+
+func unlockFile(f *os.File) error {
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		return fmt.Errorf("failed to unlock '%s': %w", f.Name(), err)
+	}
+
+	return nil
+}
@@ -2,7 +2,13 @@ package cache
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
 )
 
 func PathExists(path string) (bool, error) {
@@ -14,3 +20,146 @@ func PathExists(path string) (bool, error) {
 
 	return true, nil
 }
+
+// rename is os.Rename, replaced in tests to move across filesystems.
+var rename = os.Rename
+
+// move renames src to dst, falling back to copying and then removing src when they are on different filesystems.
+func move(src string, dst string) error {
+	err := rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyTree(src, dst); err != nil {
+		removeTree(dst)
+		return fmt.Errorf("failed to copy across filesystems: %w", err)
+	}
+
+	return removeTree(src)
+}
+
+// removeTree removes path and everything in it, including the contents of read only directories.
+func removeTree(path string) error {
+	if err := os.RemoveAll(path); err == nil {
+		return nil
+	}
+
+	// directories must be writable to remove what is in them
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(p, 0o700)
+		}
+
+		return nil
+	})
+
+	return os.RemoveAll(path)
+}
+
+// copyTree copies the file, link, or directory at src to dst keeping their modes. Files and directories are synced
+// before returning so that a copy is never seen partially written after a crash. Directories are only given their
+// modes once everything in them is copied, so that read only directories can be copied as well.
+func copyTree(src string, dst string) error {
+	type dir struct {
+		path string
+		perm fs.FileMode
+	}
+
+	var dirs []dir
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			dirs = append(dirs, dir{target, info.Mode().Perm()})
+			return os.Mkdir(target, 0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("cannot copy '%s': unsupported file type %s", path, d.Type())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := syncDir(dirs[i].path); err != nil {
+			return err
+		}
+
+		if err := os.Chmod(dirs[i].path, dirs[i].perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncDir syncs the directory at path, so that the files created in or renamed into it are not lost after a crash.
+func syncDir(path string) error {
+	// directories cannot be opened for syncing on windows
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync '%s': %w", path, err)
+	}
+
+	return nil
+}
+
+func copyFile(src string, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
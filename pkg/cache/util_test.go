@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTree writes a tree with a read only directory, an executable file, and a relative link to it at dir.
+func writeTree(t *testing.T, dir string) {
+	t.Helper()
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bin", "tool"), []byte("#!/usr/bin/env bash\nexit 0"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("read me"), 0o644))
+	assert.NoError(t, os.Symlink("bin/tool", filepath.Join(dir, "tool")))
+	assert.NoError(t, os.Chmod(filepath.Join(dir, "bin"), 0o555))
+
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(dir, "bin"), 0o755)
+	})
+}
+
+func assertTree(t *testing.T, dir string) {
+	t.Helper()
+
+	for name, expected := range map[string]os.FileMode{
+		"bin":      os.ModeDir | 0o555,
+		"bin/tool": 0o755,
+		"README":   0o644,
+	} {
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, expected, info.Mode(), name)
+		}
+	}
+
+	link, err := os.Readlink(filepath.Join(dir, "tool"))
+	assert.NoError(t, err)
+	assert.Equal(t, "bin/tool", link)
+
+	data, err := os.ReadFile(filepath.Join(dir, "tool"))
+	assert.NoError(t, err)
+	assert.Equal(t, "#!/usr/bin/env bash\nexit 0", string(data))
+}
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	writeTree(t, src)
+
+	assert.NoError(t, copyTree(src, dst))
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(dst, "bin"), 0o755)
+	})
+
+	assertTree(t, dst)
+	assertTree(t, src)
+}
+
+func TestMoveAcrossFilesystems(t *testing.T) {
+	previous := rename
+	rename = func(string, string) error {
+		return &os.LinkError{Op: "rename", Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { rename = previous })
+
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	writeTree(t, src)
+
+	assert.NoError(t, move(src, dst))
+	t.Cleanup(func() {
+		os.Chmod(filepath.Join(dst, "bin"), 0o755)
+	})
+
+	assertTree(t, dst)
+
+	exists, err := PathExists(src)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
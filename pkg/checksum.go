@@ -0,0 +1,151 @@
+package fan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	sha256FragmentKey = "sha256"
+	sha256Prefix      = "sha256:"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// fragmentValue returns the value of key in the fragment of u, which is formatted like a query string.
+func fragmentValue(u *url.URL, key string) string {
+	values, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return ""
+	}
+
+	return values.Get(key)
+}
+
+// withFragmentValue returns raw with key set to value in its fragment.
+func withFragmentValue(raw string, key string, value string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	values, _ := url.ParseQuery(u.Fragment)
+	values.Set(key, value)
+
+	// the fragment is escaped as a whole when the url is formatted
+	if u.Fragment, err = url.QueryUnescape(values.Encode()); err != nil {
+		return "", fmt.Errorf("failed to set '%s': %w", key, err)
+	}
+
+	return u.String(), nil
+}
+
+// ParseSha256 normalizes a hex encoded sha256 digest with an optional 'sha256:' prefix.
+func ParseSha256(digest string) (string, error) {
+	digest = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(digest), sha256Prefix))
+
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 digest '%s'", digest)
+	}
+
+	return digest, nil
+}
+
+// Sha256FromUrl returns the digest pinned by a '#sha256=<digest>' fragment, or an empty string if there is none.
+func Sha256FromUrl(u *url.URL) (string, error) {
+	digest := fragmentValue(u, sha256FragmentKey)
+	if digest == "" {
+		return "", nil
+	}
+
+	return ParseSha256(digest)
+}
+
+// WithSha256 returns raw with its fragment pinning the executable to digest.
+func WithSha256(raw string, digest string) (string, error) {
+	digest, err := ParseSha256(digest)
+	if err != nil {
+		return "", err
+	}
+
+	return withFragmentValue(raw, sha256FragmentKey, digest)
+}
+
+// Sha256File returns the hex encoded sha256 digest of the file at path.
+func Sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PinChanged reports whether the url of target pins a different digest than the one recorded when it was fetched.
+func (t Target) PinChanged() bool {
+	u, err := url.Parse(t.Url)
+	if err != nil {
+		return false
+	}
+
+	pinned, err := Sha256FromUrl(u)
+	if err != nil || pinned == "" {
+		return false
+	}
+
+	return t.digestApplies() && pinned != t.Sha256
+}
+
+// ContentChanged reports whether the executable of t has a different digest than the executable of previous, a
+// target fetched earlier from the same url. Targets without a recorded digest for the same executable are never
+// considered changed.
+func (t Target) ContentChanged(previous Target) bool {
+	if !t.digestApplies() || !previous.digestApplies() || t.Entrypoint != previous.Entrypoint {
+		return false
+	}
+
+	return t.Sha256 != previous.Sha256
+}
+
+// VerifyExecutable checks executable against the digest pinned in the url of target and against the digest recorded
+// on target, returning the actual digest of the executable.
+func VerifyExecutable(target Target, executable string) (string, error) {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	pinned, err := Sha256FromUrl(u)
+	if err != nil {
+		return "", err
+	}
+
+	actual, err := Sha256File(executable)
+	if err != nil {
+		return "", err
+	}
+
+	if pinned != "" && pinned != actual {
+		return "", fmt.Errorf("%w: expected sha256 '%s' but found '%s'", ErrChecksumMismatch, pinned, actual)
+	}
+
+	if target.digestApplies() && target.Sha256 != actual {
+		return "", fmt.Errorf("%w: executable no longer matches sha256 '%s'", ErrChecksumMismatch, target.Sha256)
+	}
+
+	return actual, nil
+}
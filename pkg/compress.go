@@ -0,0 +1,155 @@
+package fan
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// CompressionFormat identifies how a single file target is decompressed.
+type CompressionFormat string
+
+const (
+	CompressionNone  CompressionFormat = ""
+	CompressionGzip  CompressionFormat = "gzip"
+	CompressionXz    CompressionFormat = "xz"
+	CompressionBzip2 CompressionFormat = "bzip2"
+	CompressionZstd  CompressionFormat = "zstd"
+)
+
+var compressionSuffixes = []struct {
+	suffix string
+	format CompressionFormat
+}{
+	{".gz", CompressionGzip},
+	{".xz", CompressionXz},
+	{".bz2", CompressionBzip2},
+	{".zst", CompressionZstd},
+}
+
+var compressionMagic = map[CompressionFormat][]byte{
+	CompressionGzip:  {0x1f, 0x8b},
+	CompressionXz:    {0xfd, '7', 'z', 'X', 'Z', 0x00},
+	CompressionBzip2: []byte("BZh"),
+	CompressionZstd:  {0x28, 0xb5, 0x2f, 0xfd},
+}
+
+// compressionFormatForName returns the compression format and suffix of the given file name, or CompressionNone if
+// it is not compressed. Archives are not considered compressed since they are unpacked instead.
+func compressionFormatForName(name string) (CompressionFormat, string) {
+	if format, _ := archiveFormatForName(name); format != ArchiveNone {
+		return CompressionNone, ""
+	}
+
+	lower := strings.ToLower(name)
+
+	for _, s := range compressionSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.format, name[len(name)-len(s.suffix):]
+		}
+	}
+
+	return CompressionNone, ""
+}
+
+// CompressionFormatForUrl returns the compression format of the target at u, or CompressionNone if it is not
+// compressed.
+func CompressionFormatForUrl(u *url.URL) CompressionFormat {
+	if IsGitUrl(u) {
+		return CompressionNone
+	}
+
+	format, _ := compressionFormatForName(path.Base(u.Path))
+	return format
+}
+
+// compressionFormatForEncoding returns the compression format for a Content-Encoding header value.
+func compressionFormatForEncoding(encoding string) (CompressionFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return CompressionNone, nil
+	case "gzip", "x-gzip":
+		return CompressionGzip, nil
+	case "zstd":
+		return CompressionZstd, nil
+	case "xz":
+		return CompressionXz, nil
+	case "bzip2":
+		return CompressionBzip2, nil
+	default:
+		return CompressionNone, fmt.Errorf("unsupported content encoding '%s'", encoding)
+	}
+}
+
+// NewDecompressReader returns a reader which decompresses r according to format.
+func NewDecompressReader(format CompressionFormat, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case CompressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression format '%s'", format)
+	}
+}
+
+// decompressFile decompresses src into dst. If src does not start with the magic bytes of format, it is assumed to
+// have already been decompressed (ie by a server's Content-Encoding) and is copied as is.
+func decompressFile(format CompressionFormat, src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer in.Close()
+
+	buffered := bufio.NewReader(in)
+
+	var r io.Reader = buffered
+
+	magic := compressionMagic[format]
+	if header, _ := buffered.Peek(len(magic)); bytes.Equal(header, magic) {
+		decompressed, err := NewDecompressReader(format, buffered)
+		if err != nil {
+			return fmt.Errorf("failed to decompress file: %w", err)
+		}
+		defer decompressed.Close()
+
+		r = decompressed
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("failed to decompress file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package fan_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+func compress(t *testing.T, suffix string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch suffix {
+	case ".gz":
+		w = gzip.NewWriter(&buf)
+	case ".xz":
+		w, err = xz.NewWriter(&buf)
+	case ".zst":
+		w, err = zstd.NewWriter(&buf)
+	}
+
+	assert.NoError(t, err)
+
+	w.Write(data)
+	w.Close()
+
+	return buf.Bytes()
+}
+
+func TestDecompression(t *testing.T) {
+	script := []byte("#!/usr/bin/env bash\nexit 0")
+
+	for _, suffix := range []string{".gz", ".xz", ".zst"} {
+		t.Run(suffix, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "tool"+suffix)
+			assert.NoError(t, os.WriteFile(src, compress(t, suffix, script), 0o644))
+
+			target := fan.Target{Url: "file://" + src}
+			dst := filepath.Join(t.TempDir(), "tool")
+
+			assert.NoError(t, fan.DefaultRegistry.Fetch(&target, dst))
+			assert.Equal(t, "tool", target.ExecutableName())
+
+			data, err := os.ReadFile(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, script, data)
+		})
+	}
+
+	t.Run("ContentEncoding", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compress(t, ".gz", script))
+		}))
+		defer server.Close()
+
+		for _, name := range []string{"tool", "tool.gz"} {
+			target := fan.Target{Url: server.URL + "/" + name}
+			dst := filepath.Join(t.TempDir(), "tool")
+
+			assert.NoError(t, fan.DefaultRegistry.Fetch(&target, dst))
+
+			data, err := os.ReadFile(dst)
+			assert.NoError(t, err)
+			assert.Equal(t, script, data)
+		}
+	})
+
+	t.Run("ContentEncodingArchive", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "tool.tar.gz")
+		writeTarGz(t, archive, archiveFiles)
+
+		data, err := os.ReadFile(archive)
+		assert.NoError(t, err)
+
+		// misconfigured servers report the compression of the file itself as its encoding
+		var acceptEncoding string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(data)
+		}))
+		defer server.Close()
+
+		target := fan.Target{Url: server.URL + "/tool.tar.gz#entry=bin/tool"}
+		dst := filepath.Join(t.TempDir(), "tool")
+
+		assert.NoError(t, fan.DefaultRegistry.Fetch(&target, dst))
+		assert.Equal(t, "identity", acceptEncoding)
+
+		tool, err := os.ReadFile(filepath.Join(dst, "bin", "tool"))
+		assert.NoError(t, err)
+		assert.Equal(t, archiveFiles["bin/tool"], string(tool))
+	})
+}
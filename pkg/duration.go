@@ -0,0 +1,165 @@
+package fan
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Day  = time.Hour * 24
+	Week = Day * 7
+)
+
+// durationUnits are the units accepted by ParseDuration, units must come after any longer unit they are a prefix of.
+var durationUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"ns", time.Nanosecond},
+	{"us", time.Microsecond},
+	{"µs", time.Microsecond},
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", Day},
+	{"w", Week},
+}
+
+// Duration is a time.Duration which is written in a human readable form like '7d' or '12h30m' rather than as a
+// number of nanoseconds.
+type Duration time.Duration
+
+// ParseDuration parses a duration like time.ParseDuration, but additionally accepts days ('d') and weeks ('w').
+func ParseDuration(s string) (Duration, error) {
+	raw := s
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("invalid duration: empty string")
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	if negative || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	if s == "" {
+		return 0, fmt.Errorf("invalid duration '%s'", raw)
+	}
+
+	if s == "0" {
+		return 0, nil
+	}
+
+	var total time.Duration
+
+	for s != "" {
+		end := strings.IndexFunc(s, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if end <= 0 {
+			return 0, fmt.Errorf("invalid duration '%s'", raw)
+		}
+
+		value, err := strconv.ParseFloat(s[:end], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", raw)
+		}
+
+		s = s[end:]
+
+		found := false
+		for _, u := range durationUnits {
+			if strings.HasPrefix(s, u.suffix) {
+				total += time.Duration(value * float64(u.unit))
+				s = s[len(u.suffix):]
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("invalid duration '%s': missing or unknown unit", raw)
+		}
+	}
+
+	if negative {
+		total = -total
+	}
+
+	return Duration(total), nil
+}
+
+// String formats d using days, hours, minutes, and seconds, omitting any unit which is zero. Durations with a fraction
+// of a second are formatted like time.Duration.
+func (d Duration) String() string {
+	if d == 0 {
+		return "0s"
+	}
+
+	duration := time.Duration(d)
+	if duration%time.Second != 0 {
+		return duration.String()
+	}
+
+	var b strings.Builder
+
+	if duration < 0 {
+		b.WriteRune('-')
+		duration = -duration
+	}
+
+	for _, u := range []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"d", Day},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	} {
+		if n := duration / u.unit; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, u.suffix)
+			duration -= n * u.unit
+		}
+	}
+
+	return b.String()
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// UnmarshalYAML accepts either a duration string or, for compatibility with older configs, an integer number of
+// nanoseconds.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!int" {
+		var ns int64
+		if err := node.Decode(&ns); err != nil {
+			return err
+		}
+
+		*d = Duration(ns)
+		return nil
+	}
+
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,51 @@
+package fan_test
+
+import (
+	"testing"
+	"time"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDuration(t *testing.T) {
+	for raw, expected := range map[string]time.Duration{
+		"7d":       fan.Week,
+		"1w":       fan.Week,
+		"12h30m":   time.Hour*12 + time.Minute*30,
+		"168h0m0s": fan.Week,
+		"1.5h":     time.Hour + time.Minute*30,
+		"250ms":    time.Millisecond * 250,
+		"0":        0,
+	} {
+		parsed, err := fan.ParseDuration(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, fan.Duration(expected), parsed, raw)
+	}
+
+	for _, raw := range []string{"", "7", "d", "7y", "1h-", "-", "+", "--5m", "+-1d"} {
+		_, err := fan.ParseDuration(raw)
+		assert.Error(t, err, raw)
+	}
+
+	assert.Equal(t, "7d", fan.Duration(fan.Week).String())
+	assert.Equal(t, "1d12h30m", fan.Duration(fan.Day+time.Hour*12+time.Minute*30).String())
+	assert.Equal(t, "250ms", fan.Duration(time.Millisecond*250).String())
+
+	t.Run("YAML", func(t *testing.T) {
+		var config struct {
+			InvalidateAfter fan.Duration `yaml:"invalidate_after"`
+		}
+
+		assert.NoError(t, yaml.Unmarshal([]byte("invalidate_after: 3600000000000"), &config))
+		assert.Equal(t, fan.Duration(time.Hour), config.InvalidateAfter)
+
+		assert.NoError(t, yaml.Unmarshal([]byte("invalidate_after: 2d"), &config))
+		assert.Equal(t, fan.Duration(fan.Day*2), config.InvalidateAfter)
+
+		data, err := yaml.Marshal(config)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "invalidate_after: 2d\n")
+	})
+}
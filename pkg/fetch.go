@@ -2,15 +2,26 @@ package fan
 
 import (
 	"fmt"
-	"io"
 	"math/rand"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 )
 
 const suffixCharset = "abcdefhijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+var (
+	ErrUnsupportedScheme = fmt.Errorf("unsupported scheme")
+
+	// ErrNotFound is returned by fetchers when there is nothing at the url of the target, as opposed to failing to
+	// reach or read from its source.
+	ErrNotFound = fmt.Errorf("not found")
+
+	// ErrNotModified is returned by fetchers when a conditional request shows the target has not changed since it
+	// was last fetched.
+	ErrNotModified = fmt.Errorf("not modified")
+)
+
 func randomSuffix(l int) string {
 	suffix := make([]byte, l)
 	for i := range suffix {
@@ -19,34 +30,235 @@ func randomSuffix(l int) string {
 	return string(suffix)
 }
 
-// todo: check content-type header
-// todo: add authentication stuff (certs)
-func FetchToPath(u string, path string) (string, error) {
-	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+// Fetcher retrieves the content of a target and writes it to path. Fetchers may record any source specific metadata
+// on the given target.
+type Fetcher interface {
+	Fetch(target *Target, path string) error
+}
+
+// Validator is implemented by fetchers which can determine whether a cached target is out of date with its source.
+type Validator interface {
+	IsStale(target Target) (bool, error)
+}
+
+// Resolver is implemented by fetchers whose urls may refer to content which changes over time, like a tag, and which
+// can resolve them to a url which always refers to the same content.
+type Resolver interface {
+	Resolve(url string) (string, error)
+}
+
+// Verifier checks the authenticity of fetched content before it is unpacked or decompressed, recording the result
+// on target.
+type Verifier interface {
+	Verify(target *Target, path string) error
+}
+
+// Registry is a Fetcher which dispatches to other fetchers by the scheme of the target url. Targets which are archives
+// are unpacked into a directory at the fetched path, and compressed targets are decompressed.
+type Registry struct {
+	fetchers map[string]Fetcher
+
+	verifier Verifier
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		fetchers: make(map[string]Fetcher),
+	}
+}
+
+// Register sets the fetcher for the given scheme, replacing any fetcher which was already registered for it.
+func (r *Registry) Register(scheme string, fetcher Fetcher) {
+	r.fetchers[scheme] = fetcher
+}
+
+// SetVerifier sets the verifier used to check fetched content, a nil verifier disables verification.
+func (r *Registry) SetVerifier(verifier Verifier) {
+	r.verifier = verifier
+}
+
+// fetchVerified fetches target to path with fetcher and verifies the result if the registry has a verifier.
+func (r *Registry) fetchVerified(fetcher Fetcher, target *Target, path string) error {
+	if err := fetcher.Fetch(target, path); err != nil {
+		return err
+	}
+
+	if r.verifier == nil {
+		return nil
+	}
+
+	if err := r.verifier.Verify(target, path); err != nil {
+		return fmt.Errorf("failed to verify target: %w", err)
+	}
+
+	return nil
+}
+
+// FetcherFor returns the fetcher registered for the scheme of u.
+func (r *Registry) FetcherFor(u *url.URL) (Fetcher, error) {
+	fetcher, found := r.fetchers[u.Scheme]
+	if !found {
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedScheme, u.Scheme)
+	}
+
+	return fetcher, nil
+}
+
+// Fetch fetches target to path and verifies the executable against any digest pinned in the target url, recording
+// its digest on target.
+func (r *Registry) Fetch(target *Target, path string) error {
+	u, err := url.Parse(target.Url)
 	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
+		return fmt.Errorf("failed to parse url: %w", err)
 	}
-	defer out.Close()
 
-	resp, err := http.Get(u)
+	fetcher, err := r.FetcherFor(u)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	if err := r.fetchContent(fetcher, u, target, path); err != nil {
+		return err
+	}
+
+	digest, err := VerifyExecutable(*target, filepath.Join(path, target.EntrypointPath()))
+	if err != nil {
+		return err
+	}
+
+	target.Sha256 = digest
+
+	return nil
+}
+
+// fetchContent fetches target with fetcher, unpacking or decompressing it as required.
+func (r *Registry) fetchContent(fetcher Fetcher, u *url.URL, target *Target, path string) error {
+	if format := ArchiveFormatForUrl(u); format != ArchiveNone {
+		archive := path + ".archive"
+		defer os.Remove(archive)
+
+		if err := r.fetchVerified(fetcher, target, archive); err != nil {
+			return err
+		}
+
+		if err := unpackTarget(target, format, archive, path); err != nil {
+			return fmt.Errorf("failed to unpack archive: %w", err)
+		}
+
+		return nil
+	}
+
+	if format := CompressionFormatForUrl(u); format != CompressionNone {
+		compressed := path + ".compressed"
+		defer os.Remove(compressed)
+
+		if err := r.fetchVerified(fetcher, target, compressed); err != nil {
+			return err
+		}
+
+		if err := decompressFile(format, compressed, path); err != nil {
+			return err
+		}
+
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || 400 <= resp.StatusCode {
-		return "", fmt.Errorf("received failed status code %d", resp.StatusCode)
+	return r.fetchVerified(fetcher, target, path)
+}
+
+// IsStale dispatches to the fetcher for the target's scheme if it is a Validator, otherwise the target is never stale.
+func (r *Registry) IsStale(target Target) (bool, error) {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse url: %w", err)
 	}
 
-	_, err = io.Copy(out, resp.Body)
+	fetcher, err := r.FetcherFor(u)
 	if err != nil {
-		return "", fmt.Errorf("failed to write to file: %w", err)
+		return false, err
+	}
+
+	validator, ok := fetcher.(Validator)
+	if !ok {
+		return false, nil
+	}
+
+	return validator.IsStale(target)
+}
+
+// Resolve dispatches to the fetcher for the scheme of raw if it is a Resolver, otherwise raw is returned unchanged.
+func (r *Registry) Resolve(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	fetcher, err := r.FetcherFor(u)
+	if err != nil {
+		return "", err
+	}
+
+	resolver, ok := fetcher.(Resolver)
+	if !ok {
+		return raw, nil
+	}
+
+	return resolver.Resolve(raw)
+}
+
+// DefaultRegistry is the registry used by Fetch and FetchToPath.
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	DefaultRegistry.Register("http", &HTTPFetcher{})
+	DefaultRegistry.Register("https", &HTTPFetcher{})
+	DefaultRegistry.Register("file", &FileFetcher{})
+	DefaultRegistry.Register("s3", &S3Fetcher{})
+	DefaultRegistry.Register("oci", &OCIFetcher{})
+	DefaultRegistry.Register("sftp", &SFTPFetcher{})
+	DefaultRegistry.Register("scp", &SFTPFetcher{})
+
+	for _, transport := range []string{"http", "https", "ssh", "file"} {
+		DefaultRegistry.Register(gitSchemePrefix+transport, &GitFetcher{})
+	}
+}
+
+// NormalizeUrl converts bare absolute paths into file urls and scp style '[user@]host:path' targets into sftp urls,
+// any other value is returned unchanged.
+func NormalizeUrl(raw string) string {
+	if filepath.IsAbs(raw) {
+		u := url.URL{
+			Scheme: "file",
+			Path:   filepath.ToSlash(filepath.Clean(raw)),
+		}
+
+		return u.String()
+	}
+
+	if u, ok := scpUrl(raw); ok {
+		return u
+	}
+
+	return raw
+}
+
+// TempPath returns a new path in the system's temporary directory suitable for fetching a target to.
+func TempPath() string {
+	return filepath.Join(os.TempDir(), "fan-"+randomSuffix(8))
+}
+
+func FetchToPath(u string, path string) (string, error) {
+	target := Target{
+		Url: u,
+	}
+
+	if err := DefaultRegistry.Fetch(&target, path); err != nil {
+		return "", err
 	}
 
-	return out.Name(), nil
+	return path, nil
 }
 
 func Fetch(u string) (string, error) {
-	path := filepath.Join(os.TempDir(), "fan-"+randomSuffix(8))
-	return FetchToPath(u, path)
+	return FetchToPath(u, TempPath())
 }
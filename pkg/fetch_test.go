@@ -0,0 +1,102 @@
+package fan_test
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubFetcher writes its content to the fetched path.
+type stubFetcher struct {
+	content string
+}
+
+func (f *stubFetcher) Fetch(target *fan.Target, path string) error {
+	return os.WriteFile(path, []byte(f.content), 0o755)
+}
+
+func TestRegistry(t *testing.T) {
+	httpFetcher := &stubFetcher{content: "http"}
+	fileFetcher := &stubFetcher{content: "file"}
+
+	registry := fan.NewRegistry()
+	registry.Register("http", httpFetcher)
+	registry.Register("file", fileFetcher)
+
+	type testCase struct {
+		name     string
+		url      string
+		expected fan.Fetcher
+		err      error
+	}
+
+	cases := []testCase{
+		{name: "Http", url: "http://example.com/script.sh", expected: httpFetcher},
+		{name: "File", url: "file:///tmp/script.sh", expected: fileFetcher},
+		{name: "UnsupportedScheme", url: "ftp://example.com/script.sh", err: fan.ErrUnsupportedScheme},
+		{name: "NoScheme", url: "script.sh", err: fan.ErrUnsupportedScheme},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			u, err := url.Parse(c.url)
+			assert.NoError(t, err)
+
+			fetcher, err := registry.FetcherFor(u)
+			if c.err != nil {
+				assert.ErrorIs(t, err, c.err)
+				assert.Nil(t, fetcher)
+			} else {
+				assert.NoError(t, err)
+				assert.Same(t, c.expected, fetcher)
+			}
+		})
+	}
+
+	t.Run("Fetch", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "executable")
+		target := fan.Target{Url: "http://example.com/script.sh"}
+
+		assert.NoError(t, registry.Fetch(&target, path))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "http", string(data))
+		assert.NotEmpty(t, target.Sha256)
+	})
+
+	t.Run("FetchUnsupportedScheme", func(t *testing.T) {
+		target := fan.Target{Url: "ftp://example.com/script.sh"}
+
+		err := registry.Fetch(&target, filepath.Join(t.TempDir(), "executable"))
+		assert.ErrorIs(t, err, fan.ErrUnsupportedScheme)
+	})
+
+	t.Run("OverrideFetcher", func(t *testing.T) {
+		override := fan.NewRegistry()
+		override.Register("http", httpFetcher)
+
+		replacement := &stubFetcher{content: "replaced"}
+		override.Register("http", replacement)
+
+		fetcher, err := override.FetcherFor(&url.URL{Scheme: "http"})
+		assert.NoError(t, err)
+		assert.Same(t, replacement, fetcher)
+
+		path := filepath.Join(t.TempDir(), "executable")
+		assert.NoError(t, override.Fetch(&fan.Target{Url: "http://example.com/script.sh"}, path))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "replaced", string(data))
+
+		// overriding a fetcher on one registry leaves others untouched
+		fetcher, err = registry.FetcherFor(&url.URL{Scheme: "http"})
+		assert.NoError(t, err)
+		assert.Same(t, httpFetcher, fetcher)
+	})
+}
@@ -0,0 +1,99 @@
+package fan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// localPath returns the path on the local filesystem referenced by the file url u.
+func localPath(u *url.URL) (string, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("file url must not reference a remote host: '%s'", u.Host)
+	}
+
+	return filepath.FromSlash(u.Path), nil
+}
+
+// FileFetcher fetches targets from the local filesystem. Since the source can be checked cheaply, targets fetched
+// this way are revalidated rather than invalidated after a fixed duration, and are fetched again whenever the
+// modification time of the source changes.
+type FileFetcher struct {
+	// Symlink will link to the source rather than copying it.
+	Symlink bool
+}
+
+func (f *FileFetcher) Fetch(target *Target, path string) error {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	src, err := localPath(u)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: failed to stat source: %w", ErrNotFound, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	if info.IsDir() {
+		return fmt.Errorf("source is a directory: %s", src)
+	}
+
+	target.ModTime = info.ModTime().UTC()
+	target.Revalidate = true
+
+	if f.Symlink {
+		if err := os.Symlink(src, path); err != nil {
+			return fmt.Errorf("failed to link to source: %w", err)
+		}
+
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to write to file: %w", err)
+	}
+
+	return nil
+}
+
+// IsStale reports whether the source of target has been modified since it was fetched.
+func (f *FileFetcher) IsStale(target Target) (bool, error) {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	src, err := localPath(u)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat source: %w", err)
+	}
+
+	return !info.ModTime().UTC().Equal(target.ModTime), nil
+}
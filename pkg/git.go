@@ -0,0 +1,142 @@
+package fan
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const gitSchemePrefix = "git+"
+
+// GitUrl is a parsed git target url of the form 'git+<transport>://host/repo.git//path/to/file?ref=<ref>'.
+type GitUrl struct {
+	// Repository is the url of the repository without the leading 'git+'.
+	Repository string
+
+	// Path is the path of the selected file within the repository.
+	Path string
+
+	// Ref is the branch, tag, or commit to check out. If empty, the default branch of the repository is used.
+	Ref string
+}
+
+func IsGitUrl(u *url.URL) bool {
+	return strings.HasPrefix(u.Scheme, gitSchemePrefix)
+}
+
+func ParseGitUrl(u *url.URL) (GitUrl, error) {
+	if !IsGitUrl(u) {
+		return GitUrl{}, fmt.Errorf("not a git url: '%s'", u)
+	}
+
+	repoPath, filePath, found := strings.Cut(u.Path, "//")
+	if !found || filePath == "" {
+		return GitUrl{}, fmt.Errorf("git url does not select a file: '%s'", u)
+	}
+
+	repo := url.URL{
+		Scheme: strings.TrimPrefix(u.Scheme, gitSchemePrefix),
+		User:   u.User,
+		Host:   u.Host,
+		Path:   repoPath,
+	}
+
+	filePath = path.Clean(filePath)
+	if escapesDir(filePath) {
+		return GitUrl{}, fmt.Errorf("git url selects a file outside of the repository: '%s'", u)
+	}
+
+	ref := u.Query().Get("ref")
+	if strings.HasPrefix(ref, "-") {
+		return GitUrl{}, fmt.Errorf("invalid git ref '%s'", ref)
+	}
+
+	return GitUrl{
+		Repository: repo.String(),
+		Path:       filePath,
+		Ref:        ref,
+	}, nil
+}
+
+// RepositoryName returns the name of the repository without any '.git' suffix.
+func (g GitUrl) RepositoryName() string {
+	return strings.TrimSuffix(path.Base(g.Repository), ".git")
+}
+
+// GitFetcher fetches targets by making a shallow clone of the repository at the requested ref. The whole checkout is
+// written to path so that other files at the same ref can be run from the same cache entry.
+type GitFetcher struct {
+	// Git is the path to the git executable, if empty "git" is looked up in PATH.
+	Git string
+}
+
+func (f *GitFetcher) git(dir string, args ...string) (string, error) {
+	bin := f.Git
+	if bin == "" {
+		bin = "git"
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(bin, append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (f *GitFetcher) Fetch(target *Target, dst string) error {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	gitUrl, err := ParseGitUrl(u)
+	if err != nil {
+		return err
+	}
+
+	ref := gitUrl.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return fmt.Errorf("failed to create checkout directory: %w", err)
+	}
+
+	if _, err := f.git(dst, "init", "--quiet"); err != nil {
+		return err
+	}
+
+	if _, err := f.git(dst, "fetch", "--quiet", "--depth", "1", "--", gitUrl.Repository, ref); err != nil {
+		return err
+	}
+
+	if _, err := f.git(dst, "checkout", "--quiet", "FETCH_HEAD"); err != nil {
+		return err
+	}
+
+	commit, err := f.git(dst, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(gitUrl.Path))); err != nil {
+		return fmt.Errorf("could not find '%s' in repository: %w", gitUrl.Path, err)
+	}
+
+	target.Commit = commit
+	target.Entrypoint = gitUrl.Path
+
+	return nil
+}
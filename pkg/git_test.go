@@ -0,0 +1,118 @@
+package fan_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=fan", "-c", "user.email=fan@example.com"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %s: %s", args[0], err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// setupBareRepo creates a bare repository with two scripts tagged as v1.0.0, returning its path and the tagged commit.
+func setupBareRepo(t *testing.T) (string, string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	bare := filepath.Join(dir, "repo.git")
+
+	if err := os.MkdirAll(filepath.Join(work, "scripts"), 0o755); err != nil {
+		t.Fatalf("failed to create work tree: %s", err)
+	}
+
+	for _, name := range []string{"a.sh", "b.sh"} {
+		if err := os.WriteFile(filepath.Join(work, "scripts", name), []byte("#!/usr/bin/env bash\necho "+name), 0o755); err != nil {
+			t.Fatalf("failed to write script: %s", err)
+		}
+	}
+
+	git(t, work, "init", "--quiet")
+	git(t, work, "add", ".")
+	git(t, work, "commit", "--quiet", "-m", "initial")
+	git(t, work, "tag", "v1.0.0")
+	commit := git(t, work, "rev-parse", "HEAD")
+
+	git(t, dir, "clone", "--quiet", "--bare", work, bare)
+
+	return bare, commit
+}
+
+func TestGitFetcher(t *testing.T) {
+	bare, commit := setupBareRepo(t)
+
+	t.Run("FetchesRef", func(t *testing.T) {
+		target := fan.Target{Url: "git+file://" + bare + "//scripts/a.sh?ref=v1.0.0"}
+		dst := filepath.Join(t.TempDir(), "checkout")
+
+		err := (&fan.GitFetcher{}).Fetch(&target, dst)
+		assert.NoError(t, err)
+		assert.Equal(t, commit, target.Commit)
+		assert.FileExists(t, filepath.Join(dst, "scripts", "a.sh"))
+		assert.Equal(t, filepath.Join("repo", "scripts", "a.sh"), target.ExecutablePath())
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		target := fan.Target{Url: "git+file://" + bare + "//scripts/missing.sh?ref=v1.0.0"}
+		dst := filepath.Join(t.TempDir(), "checkout")
+
+		err := (&fan.GitFetcher{}).Fetch(&target, dst)
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsOptionRef", func(t *testing.T) {
+		target := fan.Target{Url: "git+file://" + bare + "//scripts/a.sh?ref=--upload-pack=touch%20pwned"}
+		dst := filepath.Join(t.TempDir(), "checkout")
+
+		err := (&fan.GitFetcher{}).Fetch(&target, dst)
+		assert.ErrorContains(t, err, "invalid git ref")
+		assert.NoFileExists(t, filepath.Join(dst, "pwned"))
+	})
+
+	t.Run("RejectsPathOutsideRepository", func(t *testing.T) {
+		target := fan.Target{Url: "git+file://" + bare + "//../../x?ref=v1.0.0"}
+		dst := filepath.Join(t.TempDir(), "checkout")
+
+		err := (&fan.GitFetcher{}).Fetch(&target, dst)
+		assert.ErrorContains(t, err, "outside of the repository")
+		assert.Equal(t, "", target.EntrypointPath())
+	})
+
+	t.Run("SharesCheckout", func(t *testing.T) {
+		c := cache.NewDiskCache(t.TempDir())
+
+		a := fan.Target{Url: "git+file://" + bare + "//scripts/a.sh?ref=v1.0.0", InvalidateAfter: time.Hour}
+		b := fan.Target{Url: "git+file://" + bare + "//scripts/b.sh?ref=v1.0.0", InvalidateAfter: time.Hour}
+		assert.Equal(t, a.Hash(), b.Hash())
+
+		_, executable, err := cache.FetchTarget(c, fan.DefaultRegistry, a, nil)
+		assert.NoError(t, err)
+		assert.FileExists(t, executable)
+
+		target, executable, err := c.GetTargetForUrl(b.Url)
+		assert.NoError(t, err)
+		assert.Equal(t, commit, target.Commit)
+		assert.Equal(t, "b.sh", filepath.Base(executable))
+		assert.FileExists(t, executable)
+	})
+}
@@ -0,0 +1,149 @@
+package fan
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPFetcher fetches targets over http and https. Targets which were previously fetched with an ETag or
+// Last-Modified are requested conditionally, and the server's Cache-Control header overrides the target's
+// InvalidateAfter.
+type HTTPFetcher struct {
+	// Client is the client used to make requests, if nil http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (f *HTTPFetcher) client() *http.Client {
+	if f.Client == nil {
+		return http.DefaultClient
+	}
+
+	return f.Client
+}
+
+// StatusError is returned for a failed response status code. It is ErrNotFound if there is nothing at the requested
+// url.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) notFound() bool {
+	return e.Code == http.StatusNotFound || e.Code == http.StatusGone
+}
+
+func (e *StatusError) Error() string {
+	if e.notFound() {
+		return fmt.Sprintf("%s: received failed status code %d", ErrNotFound, e.Code)
+	}
+
+	return fmt.Sprintf("received failed status code %d", e.Code)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrNotFound && e.notFound()
+}
+
+// statusError returns the error for a failed response status code.
+func statusError(code int) error {
+	return &StatusError{Code: code}
+}
+
+// applyCacheControl updates target according to the directives of a Cache-Control header.
+func applyCacheControl(target *Target, header string) {
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		switch strings.ToLower(name) {
+		case "no-store":
+			target.NoStore = true
+		case "no-cache":
+			target.InvalidateAfter = 0
+		case "max-age":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || seconds < 0 {
+				continue
+			}
+
+			target.InvalidateAfter = time.Duration(seconds) * time.Second
+		}
+	}
+}
+
+// todo: check content-type header
+// todo: add authentication stuff (certs)
+func (f *HTTPFetcher) Fetch(target *Target, path string) error {
+	req, err := http.NewRequest(http.MethodGet, target.Url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// content named as an archive or compressed file is decompressed by its name, so it is requested as is and any
+	// Content-Encoding is taken to describe that compression rather than one applied for transfer, as misconfigured
+	// servers report for '.tar.gz' files
+	decodedByName := ArchiveFormatForUrl(req.URL) != ArchiveNone || CompressionFormatForUrl(req.URL) != CompressionNone
+
+	// setting Accept-Encoding ourselves disables the transport's transparent gzip handling
+	if decodedByName {
+		req.Header.Set("Accept-Encoding", "identity")
+	} else {
+		req.Header.Set("Accept-Encoding", "gzip, zstd")
+	}
+
+	if target.ETag != "" {
+		req.Header.Set("If-None-Match", target.ETag)
+	}
+
+	if target.LastModified != "" {
+		req.Header.Set("If-Modified-Since", target.LastModified)
+	}
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	applyCacheControl(target, resp.Header.Get("Cache-Control"))
+
+	if resp.StatusCode == http.StatusNotModified {
+		return ErrNotModified
+	}
+
+	if resp.StatusCode < 200 || 400 <= resp.StatusCode {
+		return statusError(resp.StatusCode)
+	}
+
+	target.ETag = resp.Header.Get("ETag")
+	target.LastModified = resp.Header.Get("Last-Modified")
+
+	format := CompressionNone
+	if !decodedByName {
+		if format, err = compressionFormatForEncoding(resp.Header.Get("Content-Encoding")); err != nil {
+			return err
+		}
+	}
+
+	body, err := NewDecompressReader(format, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	defer body.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, body)
+	if err != nil {
+		return fmt.Errorf("failed to write to file: %w", err)
+	}
+
+	return nil
+}
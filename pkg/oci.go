@@ -0,0 +1,372 @@
+package fan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// OCIReference is a parsed 'oci://registry/repository:tag' or 'oci://registry/repository@sha256:<digest>' url.
+type OCIReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func ParseOCIUrl(u *url.URL) (OCIReference, error) {
+	if u.Host == "" {
+		return OCIReference{}, fmt.Errorf("oci url must specify a registry: '%s'", u)
+	}
+
+	ref := OCIReference{
+		Registry: u.Host,
+	}
+
+	repository := strings.TrimPrefix(u.Path, "/")
+
+	if name, digest, found := strings.Cut(repository, "@"); found {
+		repository, ref.Digest = name, digest
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, ref.Tag = repository[:i], repository[i+1:]
+	}
+
+	if repository == "" {
+		return OCIReference{}, fmt.Errorf("oci url must specify a repository: '%s'", u)
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	ref.Repository = repository
+
+	return ref, nil
+}
+
+// Name returns the last component of the repository.
+func (r OCIReference) Name() string {
+	return path.Base(r.Repository)
+}
+
+// Reference returns the digest if set, otherwise the tag.
+func (r OCIReference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+
+	return r.Tag
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// OCIFetcher fetches artifacts with a single layer from an OCI distribution registry. Registries which require a
+// token are authenticated with anonymously, or with Username and Password if set.
+type OCIFetcher struct {
+	Username string
+	Password string
+
+	// PlainHTTP will connect to registries over http rather than https.
+	PlainHTTP bool
+
+	// Client is the client used to make requests, if nil http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (f *OCIFetcher) client() *http.Client {
+	if f.Client == nil {
+		return http.DefaultClient
+	}
+
+	return f.Client
+}
+
+func (f *OCIFetcher) baseUrl(ref OCIReference) string {
+	scheme := "https"
+	if f.PlainHTTP {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s", scheme, ref.Registry, ref.Repository)
+}
+
+// parseChallenge parses a WWW-Authenticate header into its scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(header, " ")
+	params := make(map[string]string)
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+
+		key = strings.TrimSpace(key)
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = strings.TrimPrefix(value[end+2:], ",")
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+
+	return strings.ToLower(scheme), params
+}
+
+// authorize returns the Authorization header value which satisfies the given challenge.
+func (f *OCIFetcher) authorize(challenge string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+
+	switch scheme {
+	case "basic":
+		if f.Username == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "", nil)
+		req.SetBasicAuth(f.Username, f.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		realm, err := url.Parse(params["realm"])
+		if err != nil || params["realm"] == "" {
+			return "", fmt.Errorf("invalid token realm '%s'", params["realm"])
+		}
+
+		query := realm.Query()
+		for _, key := range []string{"service", "scope"} {
+			if value, found := params[key]; found {
+				query.Set(key, value)
+			}
+		}
+		realm.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create token request: %w", err)
+		}
+
+		if f.Username != "" {
+			req.SetBasicAuth(f.Username, f.Password)
+		}
+
+		resp, err := f.client().Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to request token: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("token request received failed status code %d", resp.StatusCode)
+		}
+
+		var token struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", fmt.Errorf("failed to decode token: %w", err)
+		}
+
+		if token.Token == "" {
+			token.Token = token.AccessToken
+		}
+
+		return "Bearer " + token.Token, nil
+	default:
+		return "", fmt.Errorf("unsupported authentication scheme '%s'", scheme)
+	}
+}
+
+// get performs a GET request for u, authenticating and retrying once if the registry responds with a challenge. The
+// Authorization header which was accepted is returned so that it can be reused for later requests.
+func (f *OCIFetcher) get(u string, accept []string, authorization string) (*http.Response, string, error) {
+	do := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		for _, mediaType := range accept {
+			req.Header.Add("Accept", mediaType)
+		}
+
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		return f.client().Do(req)
+	}
+
+	resp, err := do(authorization)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+
+		if authorization, err = f.authorize(resp.Header.Get("WWW-Authenticate")); err != nil {
+			return nil, "", fmt.Errorf("failed to authenticate with registry: %w", err)
+		}
+
+		if resp, err = do(authorization); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		resp.Body.Close()
+		return nil, "", statusError(resp.StatusCode)
+	}
+
+	return resp, authorization, nil
+}
+
+// verifyDigest checks that the sha256 sum of data matches digest.
+func verifyDigest(digest string, sum []byte) error {
+	algorithm, expected, _ := strings.Cut(digest, ":")
+	if algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm '%s'", algorithm)
+	}
+
+	if actual := hex.EncodeToString(sum); actual != expected {
+		return fmt.Errorf("digest mismatch: expected '%s' but found 'sha256:%s'", digest, actual)
+	}
+
+	return nil
+}
+
+// fetchManifest fetches the manifest for ref, verifying it against the digest of ref if it has one, and returns it with
+// its digest and the Authorization header which was accepted by the registry.
+func (f *OCIFetcher) fetchManifest(ref OCIReference) (ociManifest, string, string, error) {
+	resp, authorization, err := f.get(f.baseUrl(ref)+"/manifests/"+ref.Reference(), []string{ociManifestMediaType, dockerManifestMediaType}, "")
+	if err != nil {
+		return ociManifest{}, "", "", fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return ociManifest{}, "", "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	if ref.Digest != "" {
+		if err := verifyDigest(ref.Digest, sum[:]); err != nil {
+			return ociManifest{}, "", "", fmt.Errorf("failed to verify manifest: %w", err)
+		}
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ociManifest{}, "", "", fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	return manifest, digest, authorization, nil
+}
+
+// Resolve returns the url of the target pinned to the digest of the manifest its tag currently refers to, so that
+// artifacts are cached by digest. Urls which already reference a digest are returned unchanged.
+func (f *OCIFetcher) Resolve(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	ref, err := ParseOCIUrl(u)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.Digest != "" {
+		return raw, nil
+	}
+
+	_, digest, _, err := f.fetchManifest(ref)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = "/" + ref.Repository + "@" + digest
+	u.RawPath = ""
+
+	return u.String(), nil
+}
+
+func (f *OCIFetcher) Fetch(target *Target, path string) error {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	ref, err := ParseOCIUrl(u)
+	if err != nil {
+		return err
+	}
+
+	manifest, _, authorization, err := f.fetchManifest(ref)
+	if err != nil {
+		return err
+	}
+
+	if len(manifest.Layers) != 1 {
+		return fmt.Errorf("expected artifact to have a single layer but found %d", len(manifest.Layers))
+	}
+
+	layer := manifest.Layers[0]
+
+	resp, _, err := f.get(f.baseUrl(ref)+"/blobs/"+layer.Digest, nil, authorization)
+	if err != nil {
+		return fmt.Errorf("failed to fetch blob: %w", err)
+	}
+	defer resp.Body.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), resp.Body); err != nil {
+		return fmt.Errorf("failed to write to file: %w", err)
+	}
+
+	if err := verifyDigest(layer.Digest, h.Sum(nil)); err != nil {
+		return fmt.Errorf("failed to verify blob: %w", err)
+	}
+
+	target.Digest = layer.Digest
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package fan_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/joshmeranda/fan/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+const ociTestToken = "secret-token"
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func ociManifest(blob []byte) []byte {
+	manifest, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"layers": []map[string]any{
+			{
+				"mediaType": "application/vnd.oci.image.layer.v1.tar",
+				"digest":    sha256Digest(blob),
+				"size":      len(blob),
+			},
+		},
+	})
+
+	return manifest
+}
+
+// newRegistryServer starts a stand-in for an OCI registry serving 'org/tool:v1'. If requireToken is set, the registry
+// only accepts the token handed out to user:pass by its token endpoint.
+func newRegistryServer(t *testing.T, blob []byte, requireToken bool) (*httptest.Server, string) {
+	return newMovingRegistryServer(t, func() []byte { return blob }, requireToken)
+}
+
+// newMovingRegistryServer is like newRegistryServer, but 'org/tool:v1' refers to whatever blob currently returns while
+// every blob it has returned can still be fetched by digest.
+func newMovingRegistryServer(t *testing.T, blob func() []byte, requireToken bool) (*httptest.Server, string) {
+	t.Helper()
+
+	manifests := make(map[string][]byte)
+	blobs := make(map[string][]byte)
+
+	current := func() []byte {
+		b := blob()
+		manifest := ociManifest(b)
+
+		manifests[sha256Digest(manifest)] = manifest
+		blobs[sha256Digest(b)] = b
+
+		return manifest
+	}
+
+	manifestDigest := sha256Digest(current())
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]string{"token": ociTestToken})
+			return
+		}
+
+		if requireToken && r.Header.Get("Authorization") != "Bearer "+ociTestToken {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:org/tool:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		manifest := current()
+
+		if reference, found := strings.CutPrefix(r.URL.Path, "/v2/org/tool/manifests/"); found {
+			if reference != "v1" {
+				if manifest, found = manifests[reference]; !found {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Write(manifest)
+		} else if b, found := blobs[strings.TrimPrefix(r.URL.Path, "/v2/org/tool/blobs/")]; found {
+			w.Write(b)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server, manifestDigest
+}
+
+func TestOCIFetcher(t *testing.T) {
+	blob := []byte("#!/usr/bin/env bash\nexit 0")
+
+	t.Run("Anonymous", func(t *testing.T) {
+		server, _ := newRegistryServer(t, blob, false)
+		u, _ := url.Parse(server.URL)
+
+		target := fan.Target{Url: "oci://" + u.Host + "/org/tool:v1"}
+		dst := filepath.Join(t.TempDir(), "tool")
+
+		err := (&fan.OCIFetcher{PlainHTTP: true}).Fetch(&target, dst)
+		assert.NoError(t, err)
+		assert.Equal(t, sha256Digest(blob), target.Digest)
+		assert.Equal(t, "tool", target.ExecutableName())
+
+		data, err := os.ReadFile(dst)
+		assert.NoError(t, err)
+		assert.Equal(t, blob, data)
+	})
+
+	t.Run("ByDigest", func(t *testing.T) {
+		server, manifestDigest := newRegistryServer(t, blob, false)
+		u, _ := url.Parse(server.URL)
+
+		target := fan.Target{Url: "oci://" + u.Host + "/org/tool@" + manifestDigest}
+
+		err := (&fan.OCIFetcher{PlainHTTP: true}).Fetch(&target, filepath.Join(t.TempDir(), "tool"))
+		assert.NoError(t, err)
+		assert.Equal(t, sha256Digest(blob), target.Digest)
+	})
+
+	t.Run("Token", func(t *testing.T) {
+		server, _ := newRegistryServer(t, blob, true)
+		u, _ := url.Parse(server.URL)
+
+		target := fan.Target{Url: "oci://" + u.Host + "/org/tool:v1"}
+
+		err := (&fan.OCIFetcher{PlainHTTP: true, Username: "user", Password: "pass"}).Fetch(&target, filepath.Join(t.TempDir(), "tool"))
+		assert.NoError(t, err)
+		assert.Equal(t, sha256Digest(blob), target.Digest)
+
+		err = (&fan.OCIFetcher{PlainHTTP: true}).Fetch(&target, filepath.Join(t.TempDir(), "tool"))
+		assert.Error(t, err)
+	})
+}
+
+func TestOCIFetcherCache(t *testing.T) {
+	blob := []byte("#!/usr/bin/env bash\necho v1")
+
+	server, manifestDigest := newMovingRegistryServer(t, func() []byte { return blob }, false)
+	u, _ := url.Parse(server.URL)
+
+	registry := fan.NewRegistry()
+	registry.Register("oci", &fan.OCIFetcher{PlainHTTP: true})
+
+	c := cache.NewDiskCache(t.TempDir())
+
+	tagged := fan.Target{Url: "oci://" + u.Host + "/org/tool:v1", InvalidateAfter: time.Hour}
+	pinned := fan.Target{Url: "oci://" + u.Host + "/org/tool@" + manifestDigest, InvalidateAfter: time.Hour}
+
+	t.Run("CachedByDigest", func(t *testing.T) {
+		target, executable, err := cache.FetchTarget(c, registry, tagged, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, pinned.Url, target.Url)
+
+		_, pinnedExecutable, err := c.GetTargetForUrl(pinned.Url)
+		assert.NoError(t, err)
+		assert.Equal(t, executable, pinnedExecutable)
+
+		_, pinnedExecutable, err = cache.FetchTarget(c, registry, pinned, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, executable, pinnedExecutable)
+	})
+
+	t.Run("MovedTag", func(t *testing.T) {
+		blob = []byte("#!/usr/bin/env bash\necho v2")
+
+		target, executable, err := cache.FetchTarget(c, registry, tagged, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "oci://"+u.Host+"/org/tool@"+sha256Digest(ociManifest(blob)), target.Url)
+
+		data, err := os.ReadFile(executable)
+		assert.NoError(t, err)
+		assert.Equal(t, blob, data)
+
+		// the previous digest is still cached
+		_, _, err = c.GetTargetForUrl(pinned.Url)
+		assert.NoError(t, err)
+	})
+}
+
+func TestParseOCIUrl(t *testing.T) {
+	for raw, expected := range map[string]fan.OCIReference{
+		"oci://ghcr.io/org/tool:v1":     {Registry: "ghcr.io", Repository: "org/tool", Tag: "v1"},
+		"oci://localhost:5000/tool":     {Registry: "localhost:5000", Repository: "tool", Tag: "latest"},
+		"oci://ghcr.io/tool@sha256:abc": {Registry: "ghcr.io", Repository: "tool", Digest: "sha256:abc"},
+	} {
+		u, _ := url.Parse(raw)
+		ref, err := fan.ParseOCIUrl(u)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ref)
+	}
+}
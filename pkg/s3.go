@@ -0,0 +1,351 @@
+package fan
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+
+	s3Algorithm = "AWS4-HMAC-SHA256"
+
+	// emptyPayloadHash is the hex encoded sha256 of an empty body.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Credentials are the credentials used to sign requests to S3.
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// S3CredentialsFromEnv loads credentials from the standard AWS environment variables, returning false if they are not
+// set.
+func S3CredentialsFromEnv() (S3Credentials, bool) {
+	creds := S3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	return creds, creds.AccessKeyID != "" && creds.SecretAccessKey != ""
+}
+
+// S3CredentialsFromFile loads the credentials for profile from an AWS shared credentials file.
+func S3CredentialsFromFile(path string, profile string) (S3Credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return S3Credentials{}, fmt.Errorf("failed to open credentials file: %w", err)
+	}
+	defer f.Close()
+
+	var creds S3Credentials
+	found := false
+	current := ""
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = strings.TrimSpace(strings.TrimPrefix(line[1:len(line)-1], "profile "))
+			if current == profile {
+				found = true
+			}
+			continue
+		}
+
+		if current != profile {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return S3Credentials{}, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	if !found {
+		return S3Credentials{}, fmt.Errorf("no profile '%s' in credentials file", profile)
+	}
+
+	return creds, nil
+}
+
+// S3Fetcher fetches 's3://bucket/key' targets from S3 compatible object storage using path style requests signed
+// with AWS Signature Version 4. Credentials are read from the environment, falling back to the shared credentials
+// file. If neither is available, requests are sent anonymously.
+type S3Fetcher struct {
+	// Region is the region of the bucket, if empty AWS_REGION or us-east-1 is used.
+	Region string
+
+	// Endpoint is the base url of the storage service, if empty the AWS endpoint for Region is used.
+	Endpoint string
+
+	// Profile is the profile to load from the credentials file, if empty AWS_PROFILE or default is used.
+	Profile string
+
+	// CredentialsFile is the path to the shared credentials file, if empty AWS_SHARED_CREDENTIALS_FILE or
+	// ~/.aws/credentials is used.
+	CredentialsFile string
+
+	// Client is the client used to make requests, if nil http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (f *S3Fetcher) region() string {
+	if f.Region != "" {
+		return f.Region
+	}
+
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	return defaultS3Region
+}
+
+func (f *S3Fetcher) endpoint() string {
+	if f.Endpoint != "" {
+		return strings.TrimSuffix(f.Endpoint, "/")
+	}
+
+	return fmt.Sprintf("https://s3.%s.amazonaws.com", f.region())
+}
+
+func (f *S3Fetcher) client() *http.Client {
+	if f.Client == nil {
+		return http.DefaultClient
+	}
+
+	return f.Client
+}
+
+// credentials returns the credentials to sign requests with, or false if requests should be anonymous.
+func (f *S3Fetcher) credentials() (S3Credentials, bool, error) {
+	if creds, ok := S3CredentialsFromEnv(); ok {
+		return creds, true, nil
+	}
+
+	path := f.CredentialsFile
+	if path == "" {
+		path = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return S3Credentials{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return S3Credentials{}, false, nil
+	}
+
+	profile := f.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	creds, err := S3CredentialsFromFile(path, profile)
+	if err != nil {
+		return S3Credentials{}, false, err
+	}
+
+	return creds, true, nil
+}
+
+func (f *S3Fetcher) Fetch(target *Target, path string) error {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("s3 url must specify a bucket and key: '%s'", target.Url)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, f.endpoint()+"/"+u.Host+u.EscapedPath(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	creds, ok, err := f.credentials()
+	if err != nil {
+		return fmt.Errorf("failed to load credentials: %w", err)
+	}
+
+	if ok {
+		SignS3Request(req, creds, f.region(), time.Now())
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return statusError(resp.StatusCode)
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return fmt.Errorf("failed to write to file: %w", err)
+	}
+
+	target.ETag = resp.Header.Get("ETag")
+
+	return nil
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes s as required by the canonical request, leaving '/' unescaped when escapeSlash is false.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// SignS3Request signs req with AWS Signature Version 4 for the s3 service. Requests are expected to have no body.
+func SignS3Request(req *http.Request, creds S3Credentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{
+		"host": host,
+	}
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+
+	canonicalPath := req.URL.Path
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(canonicalPath, false),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, "s3", "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
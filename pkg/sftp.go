@@ -0,0 +1,244 @@
+package fan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultSSHPort = "22"
+
+	// sftpHomePrefix marks an sftp url path as relative to the user's home directory.
+	sftpHomePrefix = "/~/"
+)
+
+var (
+	// scpHostPattern matches the '[user@]host' of an scp style target. Single letter hosts are left out since they
+	// are more likely windows drive letters, like git does.
+	scpHostPattern = regexp.MustCompile(`^([^@\s/\\]+@)?([A-Za-z0-9][A-Za-z0-9.-]*[A-Za-z0-9])$`)
+
+	// scpPortPattern matches a path which is more likely the port of a 'host:port/path' target missing its scheme.
+	scpPortPattern = regexp.MustCompile(`^[0-9]+(/|$)`)
+)
+
+// scpUrl converts an scp style '[user@]host:path' target into an sftp url, returning false if raw is not of that form.
+// Like git, raw is only considered scp style if it has no '://' and its first ':' comes before any '/'. The host must
+// also look like a hostname or ip address, and the path must not look like a port.
+func scpUrl(raw string) (string, bool) {
+	if strings.Contains(raw, "://") {
+		return "", false
+	}
+
+	host, p, found := strings.Cut(raw, ":")
+	if !found || p == "" || !scpHostPattern.MatchString(host) || scpPortPattern.MatchString(p) {
+		return "", false
+	}
+
+	u := url.URL{
+		Scheme: "sftp",
+	}
+
+	if username, hostname, found := strings.Cut(host, "@"); found {
+		u.User = url.User(username)
+		u.Host = hostname
+	} else {
+		u.Host = host
+	}
+
+	if strings.HasPrefix(p, "/") {
+		u.Path = p
+	} else {
+		u.Path = sftpHomePrefix + p
+	}
+
+	return u.String(), true
+}
+
+// SFTPFetcher fetches 'sftp://[user@]host[:port]/path' targets over ssh. A path starting with '/~/' is relative to the
+// home directory of the user. Hosts are verified against known_hosts and users are authenticated with the ssh agent
+// and any available key files.
+type SFTPFetcher struct {
+	// User is the user to connect as when the url does not specify one, if empty the current user is used.
+	User string
+
+	// IdentityFiles are the private keys to authenticate with, if empty the default keys in ~/.ssh are used.
+	IdentityFiles []string
+
+	// KnownHostsFiles are the files used to verify host keys, if empty ~/.ssh/known_hosts is used.
+	KnownHostsFiles []string
+
+	// Timeout is the maximum amount of time to wait for the connection to be established.
+	Timeout time.Duration
+}
+
+func (f *SFTPFetcher) user(u *url.URL) string {
+	if username := u.User.Username(); username != "" {
+		return username
+	}
+
+	if f.User != "" {
+		return f.User
+	}
+
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+func (f *SFTPFetcher) sshPath(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".ssh", name)
+}
+
+// authMethods returns the methods to authenticate with, and a function closing the connection to the ssh agent which
+// must be called once authentication is done.
+func (f *SFTPFetcher) authMethods() ([]ssh.AuthMethod, func()) {
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { conn.Close() }
+		}
+	}
+
+	identityFiles := f.IdentityFiles
+	if len(identityFiles) == 0 {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			identityFiles = append(identityFiles, f.sshPath(name))
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, path := range identityFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		// keys protected by a passphrase are expected to be loaded into the agent
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	return methods, closeAgent
+}
+
+func (f *SFTPFetcher) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	files := f.KnownHostsFiles
+	if len(files) == 0 {
+		files = []string{f.sshPath("known_hosts")}
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		var keyErr *knownhosts.KeyError
+		if err := callback(hostname, remote, key); errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host '%s' is not in known hosts", hostname)
+		} else if err != nil {
+			return err
+		}
+
+		return nil
+	}, nil
+}
+
+func (f *SFTPFetcher) Fetch(target *Target, path string) error {
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("sftp url must specify a host: '%s'", target.Url)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = defaultSSHPort
+	}
+
+	hostKeyCallback, err := f.hostKeyCallback()
+	if err != nil {
+		return err
+	}
+
+	auth, closeAgent := f.authMethods()
+
+	// the agent is only needed until the connection is authenticated
+	client, err := ssh.Dial("tcp", net.JoinHostPort(u.Hostname(), port), &ssh.ClientConfig{
+		User:            f.user(u),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         f.Timeout,
+	})
+	closeAgent()
+	if err != nil {
+		return fmt.Errorf("failed to connect to host: %w", err)
+	}
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	defer sftpClient.Close()
+
+	remotePath := u.Path
+	if strings.HasPrefix(remotePath, sftpHomePrefix) {
+		remotePath = strings.TrimPrefix(remotePath, sftpHomePrefix)
+	}
+
+	in, err := sftpClient.Open(remotePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: failed to open remote file: %w", ErrNotFound, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to write to file: %w", err)
+	}
+
+	return nil
+}
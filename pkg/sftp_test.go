@@ -0,0 +1,202 @@
+package fan_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newSFTPServer starts an sftp server rooted at root which accepts only clientKey, returning its address.
+func newSFTPServer(t *testing.T, root string, hostKey ssh.Signer, clientKey ssh.PublicKey) string {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+
+				for newChannel := range channels {
+					channel, channelRequests, err := newChannel.Accept()
+					if err != nil {
+						continue
+					}
+
+					go func() {
+						for req := range channelRequests {
+							req.Reply(req.Type == "subsystem", nil)
+
+							if req.Type == "subsystem" {
+								server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(root))
+								if err == nil {
+									server.Serve()
+								}
+								channel.Close()
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestSFTPFetcher(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	script := []byte("#!/usr/bin/env bash\nexit 0")
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "scripts"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "scripts", "deploy.sh"), script, 0o755))
+
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, _ := ssh.NewSignerFromKey(hostPriv)
+
+	clientPub, clientPriv, _ := ed25519.GenerateKey(rand.Reader)
+	clientKey, _ := ssh.NewPublicKey(clientPub)
+
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	assert.NoError(t, err)
+
+	identityFile := filepath.Join(dir, "id_ed25519")
+	assert.NoError(t, os.WriteFile(identityFile, pem.EncodeToMemory(block), 0o600))
+
+	addr := newSFTPServer(t, root, hostKey, clientKey)
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	assert.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey.PublicKey())+"\n"), 0o600))
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	t.Run("Fetch", func(t *testing.T) {
+		fetcher := &fan.SFTPFetcher{
+			IdentityFiles:   []string{identityFile},
+			KnownHostsFiles: []string{knownHostsFile},
+		}
+
+		target := fan.Target{Url: "sftp://ops@" + addr + "/~/scripts/deploy.sh"}
+		dst := filepath.Join(t.TempDir(), "deploy.sh")
+
+		assert.NoError(t, fetcher.Fetch(&target, dst))
+
+		data, err := os.ReadFile(dst)
+		assert.NoError(t, err)
+		assert.Equal(t, script, data)
+	})
+
+	t.Run("Agent", func(t *testing.T) {
+		keyring := agent.NewKeyring()
+		assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: clientPriv}))
+
+		sock := filepath.Join(t.TempDir(), "agent.sock")
+		listener, err := net.Listen("unix", sock)
+		assert.NoError(t, err)
+		defer listener.Close()
+
+		// the fetcher is expected to close its connection to the agent, ending ServeAgent
+		served := make(chan error, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				served <- err
+				return
+			}
+			defer conn.Close()
+
+			served <- agent.ServeAgent(keyring, conn)
+		}()
+
+		t.Setenv("SSH_AUTH_SOCK", sock)
+
+		fetcher := &fan.SFTPFetcher{
+			IdentityFiles:   []string{filepath.Join(t.TempDir(), "missing")},
+			KnownHostsFiles: []string{knownHostsFile},
+		}
+
+		target := fan.Target{Url: "sftp://ops@" + addr + "/~/scripts/deploy.sh"}
+		assert.NoError(t, fetcher.Fetch(&target, filepath.Join(t.TempDir(), "deploy.sh")))
+
+		select {
+		case err := <-served:
+			assert.ErrorIs(t, err, io.EOF)
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection to the agent was not closed")
+		}
+	})
+
+	t.Run("UnknownHost", func(t *testing.T) {
+		emptyKnownHosts := filepath.Join(t.TempDir(), "known_hosts")
+		assert.NoError(t, os.WriteFile(emptyKnownHosts, nil, 0o600))
+
+		fetcher := &fan.SFTPFetcher{
+			IdentityFiles:   []string{identityFile},
+			KnownHostsFiles: []string{emptyKnownHosts},
+		}
+
+		target := fan.Target{Url: "sftp://ops@" + addr + "/~/scripts/deploy.sh"}
+		assert.Error(t, fetcher.Fetch(&target, filepath.Join(t.TempDir(), "deploy.sh")))
+	})
+}
+
+func TestNormalizeUrl(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"/mnt/tools/deploy.sh":             "file:///mnt/tools/deploy.sh",
+		"ops@bastion:scripts/deploy.sh":    "sftp://ops@bastion/~/scripts/deploy.sh",
+		"bastion:/opt/scripts/deploy.sh":   "sftp://bastion/opt/scripts/deploy.sh",
+		"https://example.com/deploy.sh":    "https://example.com/deploy.sh",
+		"sftp://ops@bastion/opt/deploy.sh": "sftp://ops@bastion/opt/deploy.sh",
+		"10.0.0.5:scripts/8080.sh":         "sftp://10.0.0.5/~/scripts/8080.sh",
+
+		// not scp style
+		"localhost:8080/deploy.sh": "localhost:8080/deploy.sh",
+		"localhost:8080":           "localhost:8080",
+		`C:\tools\deploy.sh`:       `C:\tools\deploy.sh`,
+		"C:/tools/deploy.sh":       "C:/tools/deploy.sh",
+		"ops@:deploy.sh":           "ops@:deploy.sh",
+		"bastion host:deploy.sh":   "bastion host:deploy.sh",
+		"-bastion:deploy.sh":       "-bastion:deploy.sh",
+		"./bastion:deploy.sh":      "./bastion:deploy.sh",
+	} {
+		assert.Equal(t, expected, fan.NormalizeUrl(raw))
+	}
+}
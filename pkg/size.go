@@ -0,0 +1,138 @@
+package fan
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Byte ByteSize = 1
+
+	KB = Byte * 1000
+	MB = KB * 1000
+	GB = MB * 1000
+	TB = GB * 1000
+
+	KiB = Byte * 1024
+	MiB = KiB * 1024
+	GiB = MiB * 1024
+	TiB = GiB * 1024
+)
+
+// sizeUnits are the units accepted by ParseByteSize, matched ignoring case.
+var sizeUnits = map[string]ByteSize{
+	"":    Byte,
+	"b":   Byte,
+	"k":   KB,
+	"kb":  KB,
+	"m":   MB,
+	"mb":  MB,
+	"g":   GB,
+	"gb":  GB,
+	"t":   TB,
+	"tb":  TB,
+	"kib": KiB,
+	"mib": MiB,
+	"gib": GiB,
+	"tib": TiB,
+}
+
+// ByteSize is a number of bytes which is written in a human readable form like '500MB' or '2GiB'.
+type ByteSize int64
+
+// ParseByteSize parses a size like '500MB', '1.5GiB', or '1024'. Units without an 'i' are powers of 1000 and those
+// with one are powers of 1024, a number without a unit is a number of bytes.
+func ParseByteSize(s string) (ByteSize, error) {
+	raw := s
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("invalid size: empty string")
+	}
+
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end < 0 {
+		end = len(s)
+	}
+
+	if end == 0 {
+		return 0, fmt.Errorf("invalid size '%s'", raw)
+	}
+
+	value, err := strconv.ParseFloat(s[:end], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", raw)
+	}
+
+	unit, found := sizeUnits[strings.ToLower(strings.TrimSpace(s[end:]))]
+	if !found {
+		return 0, fmt.Errorf("invalid size '%s': unknown unit", raw)
+	}
+
+	size := value * float64(unit)
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size '%s': too large", raw)
+	}
+
+	return ByteSize(size), nil
+}
+
+// String formats s with the largest binary unit it is a whole multiple of, or as a number of bytes.
+func (s ByteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		unit   ByteSize
+	}{
+		{"TiB", TiB},
+		{"GiB", GiB},
+		{"MiB", MiB},
+		{"KiB", KiB},
+		{"TB", TB},
+		{"GB", GB},
+		{"MB", MB},
+		{"KB", KB},
+	} {
+		if s != 0 && s%u.unit == 0 {
+			return fmt.Sprintf("%d%s", s/u.unit, u.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%dB", int64(s))
+}
+
+func (s ByteSize) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// UnmarshalYAML accepts either a size string or an integer number of bytes.
+func (s *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!int" {
+		var n int64
+		if err := node.Decode(&n); err != nil {
+			return err
+		}
+
+		*s = ByteSize(n)
+		return nil
+	}
+
+	var raw string
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+
+	parsed, err := ParseByteSize(raw)
+	if err != nil {
+		return err
+	}
+
+	*s = parsed
+	return nil
+}
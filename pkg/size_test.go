@@ -0,0 +1,34 @@
+package fan_test
+
+import (
+	"testing"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestByteSize(t *testing.T) {
+	for raw, expected := range map[string]fan.ByteSize{
+		"500MB":  fan.MB * 500,
+		"500mb":  fan.MB * 500,
+		"2GiB":   fan.GiB * 2,
+		"1.5K":   1500,
+		"1024":   fan.KiB,
+		"64 KiB": fan.KiB * 64,
+		"0":      0,
+	} {
+		parsed, err := fan.ParseByteSize(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, expected, parsed, raw)
+	}
+
+	for _, raw := range []string{"", "MB", "5XB", "1.2.3MB"} {
+		_, err := fan.ParseByteSize(raw)
+		assert.Error(t, err, raw)
+	}
+
+	assert.Equal(t, "500MB", (fan.MB * 500).String())
+	assert.Equal(t, "2GiB", (fan.GiB * 2).String())
+	assert.Equal(t, "1001B", fan.ByteSize(1001).String())
+	assert.Equal(t, "0B", fan.ByteSize(0).String())
+}
@@ -1,7 +1,11 @@
 package fan
 
 import (
+	"fmt"
 	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,6 +23,64 @@ type Target struct {
 	InvalidateAfter time.Duration `yaml:"invalidate_after"`
 
 	CachedAt time.Time `yaml:"cached_at"`
+
+	// AccessedAt is the last time the target was fetched from the cache, used to evict the least recently used targets.
+	AccessedAt time.Time `yaml:"accessed_at,omitempty"`
+
+	// Size is the number of bytes the executable, or all files unpacked from an archive, take up in the cache.
+	Size int64 `yaml:"size,omitempty"`
+
+	// Blob is the digest the content of the target is stored under in the cache, which may be shared with other
+	// targets with the same content.
+	Blob string `yaml:"blob,omitempty"`
+
+	// ModTime is the modification time of a local source when it was fetched.
+	ModTime time.Time `yaml:"mod_time,omitempty"`
+
+	// Revalidate is set for targets which never expire, but are instead checked against their source each time they
+	// are used, like local targets whose modification time is compared.
+	Revalidate bool `yaml:"revalidate,omitempty"`
+
+	// Commit is the resolved commit sha of a git target.
+	Commit string `yaml:"commit,omitempty"`
+
+	// ETag is the entity tag of the fetched content as reported by the source.
+	ETag string `yaml:"etag,omitempty"`
+
+	// LastModified is the Last-Modified header of the fetched content as reported by the source.
+	LastModified string `yaml:"last_modified,omitempty"`
+
+	// NoStore is set when the source does not allow the target to be kept after it is run.
+	NoStore bool `yaml:"no_store,omitempty"`
+
+	// Sha256 is the hex encoded digest of the executable when it was fetched.
+	Sha256 string `yaml:"sha256,omitempty"`
+
+	// Signature is the detached signature the target was verified with when it was fetched, if any.
+	Signature *Signature `yaml:"signature,omitempty"`
+
+	// Digest is the content digest of an oci artifact layer.
+	Digest string `yaml:"digest,omitempty"`
+
+	// Entrypoint is the path of the executable within an archive or git target when it was fetched.
+	Entrypoint string `yaml:"entrypoint,omitempty"`
+
+	// Files are the paths of all files unpacked from an archive target.
+	Files []string `yaml:"files,omitempty"`
+}
+
+// Signature records a detached signature which a target was verified with.
+type Signature struct {
+	// Format is the format of the signature, ie "minisign" or "ssh".
+	Format string `yaml:"format"`
+
+	// KeyID identifies the trusted key which made the signature.
+	KeyID string `yaml:"key_id"`
+
+	// Url is the location the signature was fetched from.
+	Url string `yaml:"url"`
+
+	VerifiedAt time.Time `yaml:"verified_at"`
 }
 
 func (t Target) ExecutableName() string {
@@ -28,9 +90,27 @@ func (t Target) ExecutableName() string {
 	}
 
 	switch {
+	case IsGitUrl(u):
+		gitUrl, err := ParseGitUrl(u)
+		if err != nil {
+			return defaultTargetExecutableFile
+		}
+		return gitUrl.RepositoryName()
+	case u.Scheme == "oci":
+		ref, err := ParseOCIUrl(u)
+		if err != nil {
+			return defaultTargetExecutableFile
+		}
+		return ref.Name()
 	case u.Path != "":
-		components := strings.Split(u.Path, "/")
-		return components[len(components)-1]
+		name := path.Base(u.Path)
+		if _, suffix := archiveFormatForName(name); suffix != "" {
+			return strings.TrimSuffix(name, suffix)
+		}
+		if _, suffix := compressionFormatForName(name); suffix != "" {
+			return strings.TrimSuffix(name, suffix)
+		}
+		return name
 	case u.Host != "":
 		components := strings.Split(u.Host, ":")
 		return components[0]
@@ -39,10 +119,126 @@ func (t Target) ExecutableName() string {
 	}
 }
 
+// ExecutablePath returns the path to the executable relative to the target's cache directory. For most targets this
+// is just the ExecutableName, but targets which fetch a directory select a file inside it.
+func (t Target) ExecutablePath() string {
+	return filepath.Join(t.ExecutableName(), t.EntrypointPath())
+}
+
+// selectedEntrypoint returns the slash separated path of the executable selected from a directory target, or an
+// empty string if the fetched content is the executable itself.
+func (t Target) selectedEntrypoint() string {
+	u, err := url.Parse(t.Url)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case IsGitUrl(u):
+		gitUrl, err := ParseGitUrl(u)
+		if err != nil {
+			return ""
+		}
+		return gitUrl.Path
+	case ArchiveFormatForUrl(u) != ArchiveNone:
+		if entrypoint := EntrypointFromUrl(u); entrypoint != "" {
+			return path.Clean(entrypoint)
+		}
+		return t.Entrypoint
+	default:
+		return ""
+	}
+}
+
+// escapesDir reports whether the cleaned slash separated path p refers to something outside of the directory it is
+// relative to.
+func escapesDir(p string) bool {
+	return path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../")
+}
+
+// ValidateEntrypoint checks that the executable selected by the url of the target is one of the files fetched for it.
+// Targets which share a cache entry may select a different executable than the one they were fetched with, which
+// must still be inside the fetched content, and for archives must be one of the unpacked files.
+func (t Target) ValidateEntrypoint() error {
+	u, err := url.Parse(t.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	if IsGitUrl(u) {
+		_, err := ParseGitUrl(u)
+		return err
+	}
+
+	entrypoint := t.selectedEntrypoint()
+	if entrypoint == "" || entrypoint == t.Entrypoint {
+		return nil
+	}
+
+	if escapesDir(entrypoint) {
+		return fmt.Errorf("entrypoint '%s' is outside of the archive", entrypoint)
+	}
+
+	if i := sort.SearchStrings(t.Files, entrypoint); i == len(t.Files) || t.Files[i] != entrypoint {
+		return fmt.Errorf("entrypoint '%s' not found in archive", entrypoint)
+	}
+
+	return nil
+}
+
+// EntrypointPath returns selectedEntrypoint as a path relative to the fetched content.
+func (t Target) EntrypointPath() string {
+	return filepath.FromSlash(t.selectedEntrypoint())
+}
+
+// digestApplies reports whether Sha256 was recorded for the currently selected executable. Targets which share a
+// cache entry may select a different executable than the one the target was fetched for.
+func (t Target) digestApplies() bool {
+	return t.Sha256 != "" && t.selectedEntrypoint() == t.Entrypoint
+}
+
+// IsExpired reports whether the target has been cached for longer than InvalidateAfter. Targets which are revalidated
+// against their source never expire, while targets which may not be stored are always expired.
+func (t Target) IsExpired() bool {
+	if t.NoStore {
+		return true
+	}
+
+	if t.Revalidate {
+		return false
+	}
+
+	return time.Now().UTC().After(t.CachedAt.Add(t.InvalidateAfter))
+}
+
+// cacheKey returns the part of the url which identifies the fetched content. Targets which only differ in the file
+// selected from that content share the same cache entry, so the fragment and any git file path are dropped.
+func (t Target) cacheKey() string {
+	u, err := url.Parse(t.Url)
+	if err != nil {
+		return t.Url
+	}
+
+	if u.Fragment == "" && !IsGitUrl(u) {
+		return t.Url
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if IsGitUrl(u) {
+		repoPath, _, _ := strings.Cut(u.Path, "//")
+		u.Path = repoPath
+		u.RawPath = ""
+	}
+
+	return u.String()
+}
+
 func (t Target) Hash() uint64 {
 	h := xxhash.New()
 
-	h.Write([]byte(t.Url))
+	h.Write([]byte(t.cacheKey()))
 
 	return h.Sum64()
 }
@@ -0,0 +1,33 @@
+package fan_test
+
+import (
+	"testing"
+	"time"
+
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsExpired(t *testing.T) {
+	now := time.Now().UTC()
+
+	type testCase struct {
+		name     string
+		target   fan.Target
+		expected bool
+	}
+
+	cases := []testCase{
+		{name: "NotExpired", target: fan.Target{CachedAt: now, InvalidateAfter: time.Hour}},
+		{name: "Expired", target: fan.Target{CachedAt: now.Add(-2 * time.Hour), InvalidateAfter: time.Hour}, expected: true},
+		{name: "ZeroInvalidateAfter", target: fan.Target{CachedAt: now.Add(-time.Second)}, expected: true},
+		{name: "Revalidate", target: fan.Target{CachedAt: now.Add(-2 * time.Hour), InvalidateAfter: time.Hour, Revalidate: true}},
+		{name: "NoStore", target: fan.Target{CachedAt: now, InvalidateAfter: time.Hour, NoStore: true}, expected: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, c.target.IsExpired())
+		})
+	}
+}
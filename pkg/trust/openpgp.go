@@ -0,0 +1,50 @@
+package trust
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+// LoadKeyring reads the OpenPGP public keys from the ASCII-armored keyring at path.
+func LoadKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keyring: %w", err)
+	}
+	defer f.Close()
+
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring '%s': %w", path, err)
+	}
+
+	return keyring, nil
+}
+
+// isOpenPGPSignaturePacket reports whether b is the tag of a binary OpenPGP signature packet in either the old or new
+// packet format.
+func isOpenPGPSignaturePacket(b byte) bool {
+	return b&0xfc == 0x88 || b == 0xc2
+}
+
+// verifyOpenPGP verifies an ASCII-armored or binary detached signature.
+func (v *Verifier) verifyOpenPGP(message []byte, signature []byte) (string, error) {
+	check := openpgp.CheckArmoredDetachedSignature
+	if len(signature) > 0 && isOpenPGPSignaturePacket(signature[0]) {
+		check = openpgp.CheckDetachedSignature
+	}
+
+	signer, err := check(v.Keys.OpenPGP, bytes.NewReader(message), bytes.NewReader(signature), nil)
+	if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+		return "", ErrUntrustedKey
+	} else if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	return fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint), nil
+}
@@ -0,0 +1,104 @@
+package trust
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSigMagic is the preamble of ssh signatures as described by PROTOCOL.sshsig in the OpenSSH source.
+const sshSigMagic = "SSHSIG"
+
+const sshSigVersion = 1
+
+// SSHSignature is a parsed signature as produced by 'ssh-keygen -Y sign'.
+type SSHSignature struct {
+	PublicKey     ssh.PublicKey
+	Namespace     string
+	HashAlgorithm string
+	Signature     *ssh.Signature
+}
+
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSigSignedData struct {
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// ParseSSHSignature parses an armored ssh signature.
+func ParseSSHSignature(data []byte) (SSHSignature, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return SSHSignature{}, errors.New("not an armored ssh signature")
+	}
+
+	raw := block.Bytes
+	if !bytes.HasPrefix(raw, []byte(sshSigMagic)) {
+		return SSHSignature{}, errors.New("missing ssh signature preamble")
+	}
+
+	var blob sshSigBlob
+	if err := ssh.Unmarshal(raw[len(sshSigMagic):], &blob); err != nil {
+		return SSHSignature{}, fmt.Errorf("failed to decode ssh signature: %w", err)
+	}
+
+	if blob.Version != sshSigVersion {
+		return SSHSignature{}, fmt.Errorf("unsupported ssh signature version %d", blob.Version)
+	}
+
+	pub, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return SSHSignature{}, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(blob.Signature, &sig); err != nil {
+		return SSHSignature{}, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	return SSHSignature{
+		PublicKey:     pub,
+		Namespace:     blob.Namespace,
+		HashAlgorithm: blob.HashAlgorithm,
+		Signature:     &sig,
+	}, nil
+}
+
+// Verify checks that s is a valid signature of message by s.PublicKey.
+func (s SSHSignature) Verify(message []byte) error {
+	var hash []byte
+
+	switch s.HashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(message)
+		hash = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(message)
+		hash = sum[:]
+	default:
+		return fmt.Errorf("unsupported hash algorithm '%s'", s.HashAlgorithm)
+	}
+
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSigSignedData{
+		Namespace:     s.Namespace,
+		HashAlgorithm: s.HashAlgorithm,
+		Hash:          hash,
+	})...)
+
+	return s.PublicKey.Verify(signed, s.Signature)
+}
@@ -0,0 +1,98 @@
+package trust
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Record is the digest a target's executable was accepted with.
+type Record struct {
+	Url string `yaml:"url"`
+
+	// Sha256 is the hex encoded digest of the accepted executable.
+	Sha256 string `yaml:"sha256"`
+
+	AcceptedAt time.Time `yaml:"accepted_at"`
+}
+
+// Store records the digest of the executable each target url was first run with, and any changes to it which were
+// accepted since. It is kept apart from the cache so that a changed target is noticed even once its previous content
+// was cleaned, evicted, or never stored at all.
+//
+// Each url is recorded in its own file within Dir, so that concurrent runs of different targets never contend.
+type Store struct {
+	Dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{
+		Dir: dir,
+	}
+}
+
+func (s *Store) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:]))
+}
+
+// Get returns the record for url, and whether there is one.
+func (s *Store) Get(url string) (Record, bool, error) {
+	data, err := os.ReadFile(s.path(url))
+	if errors.Is(err, os.ErrNotExist) {
+		return Record{}, false, nil
+	} else if err != nil {
+		return Record{}, false, fmt.Errorf("failed to read trust record: %w", err)
+	}
+
+	var record Record
+	if err := yaml.Unmarshal(data, &record); err != nil {
+		return Record{}, false, fmt.Errorf("failed to parse trust record for '%s': %w", url, err)
+	}
+
+	return record, true, nil
+}
+
+// Accept records digest as the accepted digest of the executable for url.
+func (s *Store) Accept(url string, digest string) error {
+	data, err := yaml.Marshal(Record{
+		Url:        url,
+		Sha256:     digest,
+		AcceptedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal trust record: %w", err)
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create trust store: %w", err)
+	}
+
+	// records are replaced atomically so that a record is never seen partially written
+	f, err := os.CreateTemp(s.Dir, ".record-*")
+	if err != nil {
+		return fmt.Errorf("failed to write trust record: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write trust record: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write trust record: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), s.path(url)); err != nil {
+		return fmt.Errorf("failed to write trust record: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package trust_test
+
+import (
+	"testing"
+
+	"github.com/joshmeranda/fan/pkg/trust"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	store := trust.NewStore(t.TempDir())
+
+	_, found, err := store.Get("https://example.com/script")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, store.Accept("https://example.com/script", "abc"))
+	assert.NoError(t, store.Accept("https://example.com/script", "def"))
+	assert.NoError(t, store.Accept("https://example.com/other", "abc"))
+
+	record, found, err := store.Get("https://example.com/script")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "https://example.com/script", record.Url)
+	assert.Equal(t, "def", record.Sha256)
+}
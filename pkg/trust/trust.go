@@ -0,0 +1,314 @@
+package trust
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	fan "github.com/joshmeranda/fan/pkg"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	FormatMinisign = "minisign"
+	FormatSSH      = "ssh"
+	FormatOpenPGP  = "openpgp"
+)
+
+// DefaultSSHNamespace is the namespace ssh signatures are expected to be made for, matching the default of
+// 'ssh-keygen -Y sign'.
+const DefaultSSHNamespace = "file"
+
+var (
+	ErrUnsigned         = errors.New("target is not signed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrUntrustedKey     = errors.New("signature was not made by a trusted key")
+)
+
+// signatureSuffixes are the suffixes appended to a target's url to find its companion signature, in the order they
+// are tried.
+var signatureSuffixes = []struct {
+	suffix string
+	format string
+}{
+	{".minisig", FormatMinisign},
+	// '.sig' is used by several tools, so its format is detected from its content
+	{".sig", ""},
+	{".asc", FormatOpenPGP},
+}
+
+// unsignableSchemes are the schemes whose targets cannot have a companion signature published beside them, like oci
+// references which do not allow a suffix to be appended.
+var unsignableSchemes = map[string]bool{
+	"oci": true,
+}
+
+// isMissing reports whether err fetching the signature at u means that no signature was published. S3 denies access to
+// keys which do not exist to callers which may not list the bucket, so that is taken to mean the same.
+func isMissing(u *url.URL, err error) bool {
+	if errors.Is(err, fan.ErrNotFound) {
+		return true
+	}
+
+	var status *fan.StatusError
+	return u.Scheme == "s3" && errors.As(err, &status) && status.Code == http.StatusForbidden
+}
+
+// detectFormat returns the format of signature from its armor or header, or an empty string if it is not in a
+// supported format, like the bare base64 signatures made by cosign.
+func detectFormat(signature []byte) string {
+	trimmed := bytes.TrimSpace(signature)
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("untrusted comment:")):
+		return FormatMinisign
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN SSH SIGNATURE-----")):
+		return FormatSSH
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN PGP SIGNATURE-----")):
+		return FormatOpenPGP
+	case len(signature) > 0 && isOpenPGPSignaturePacket(signature[0]):
+		return FormatOpenPGP
+	default:
+		return ""
+	}
+}
+
+// Keys is a set of public keys trusted to sign targets.
+type Keys struct {
+	Minisign []minisign.PublicKey
+	SSH      []ssh.PublicKey
+	OpenPGP  openpgp.EntityList
+}
+
+// ParseKeys parses trusted keys, each of which is either a minisign public key, an ssh authorized key line, or a path
+// to a file containing one.
+func ParseKeys(raw []string) (Keys, error) {
+	var keys Keys
+
+	for _, key := range raw {
+		data := []byte(strings.TrimSpace(key))
+
+		if content, err := os.ReadFile(key); err == nil {
+			data = content
+		}
+
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+			keys.SSH = append(keys.SSH, pub)
+			continue
+		}
+
+		var pub minisign.PublicKey
+		if err := pub.UnmarshalText(data); err != nil {
+			return Keys{}, fmt.Errorf("failed to parse key '%s': not a minisign or ssh public key", key)
+		}
+
+		keys.Minisign = append(keys.Minisign, pub)
+	}
+
+	return keys, nil
+}
+
+func (k Keys) Empty() bool {
+	return len(k.Minisign) == 0 && len(k.SSH) == 0 && len(k.OpenPGP) == 0
+}
+
+// Verifier checks fetched targets against a companion '<url>.minisig', '<url>.sig', or '<url>.asc' signature made by one of its
+// trusted keys. A target with an invalid signature always fails verification, but a target without any signature
+// only fails if RequireSignature is set. Signatures are only considered missing if their source reports that they do
+// not exist, or for s3 that access to them is denied, any other failure to fetch them fails verification.
+type Verifier struct {
+	// Registry is used to fetch signatures.
+	Registry *fan.Registry
+
+	Keys Keys
+
+	// SSHNamespace is the namespace ssh signatures must be made for, if empty DefaultSSHNamespace is used.
+	SSHNamespace string
+
+	// RequireSignature will fail the verification of targets which do not have a signature.
+	RequireSignature bool
+}
+
+func (v *Verifier) namespace() string {
+	if v.SSHNamespace == "" {
+		return DefaultSSHNamespace
+	}
+
+	return v.SSHNamespace
+}
+
+// signatureUrl returns the url of the signature for the target at raw with the given suffix.
+func signatureUrl(raw string, suffix string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path += suffix
+
+	if u.RawPath != "" {
+		u.RawPath += suffix
+	}
+
+	return u, nil
+}
+
+// fetchSignature fetches the signature at u to a temporary file, returning its contents. Signatures are fetched
+// directly with the registered Fetcher so they are not decompressed, unpacked, or verified themselves.
+func (v *Verifier) fetchSignature(u *url.URL) ([]byte, error) {
+	fetcher, err := v.Registry.FetcherFor(u)
+	if err != nil {
+		return nil, err
+	}
+
+	path := fan.TempPath()
+	defer os.RemoveAll(path)
+
+	if err := fetcher.Fetch(&fan.Target{Url: u.String()}, path); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+func (v *Verifier) Verify(target *fan.Target, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat fetched target: %w", err)
+	}
+
+	u, err := url.Parse(target.Url)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	// there is no single file to verify in directories like git checkouts, and nowhere to look for the signature of
+	// targets with unsignable schemes
+	if info.IsDir() || unsignableSchemes[u.Scheme] {
+		if v.RequireSignature {
+			return fmt.Errorf("%w: signatures are not supported for '%s'", ErrUnsigned, target.Url)
+		}
+
+		return nil
+	}
+
+	message, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read fetched target: %w", err)
+	}
+
+	// signatures in formats which cannot be verified, rather than missing ones
+	var unsupported []string
+
+	for _, candidate := range signatureSuffixes {
+		u, err := signatureUrl(target.Url, candidate.suffix)
+		if err != nil {
+			return err
+		}
+
+		signature, err := v.fetchSignature(u)
+		if isMissing(u, err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to fetch signature '%s': %w", u, err)
+		}
+
+		format := candidate.format
+		if format == "" {
+			if format = detectFormat(signature); format == "" {
+				unsupported = append(unsupported, u.String())
+				continue
+			}
+		}
+
+		var keyID string
+
+		switch format {
+		case FormatMinisign:
+			keyID, err = v.verifyMinisign(message, signature)
+		case FormatSSH:
+			keyID, err = v.verifySSH(message, signature)
+		case FormatOpenPGP:
+			keyID, err = v.verifyOpenPGP(message, signature)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to verify signature '%s': %w", u, err)
+		}
+
+		target.Signature = &fan.Signature{
+			Format:     format,
+			KeyID:      keyID,
+			Url:        u.String(),
+			VerifiedAt: time.Now(),
+		}
+
+		return nil
+	}
+
+	if v.RequireSignature && len(unsupported) > 0 {
+		return fmt.Errorf("%w: '%s' is not a minisign, ssh, or openpgp signature", ErrUnsigned, strings.Join(unsupported, "', '"))
+	} else if v.RequireSignature {
+		return fmt.Errorf("%w: no signature found for '%s'", ErrUnsigned, target.Url)
+	}
+
+	return nil
+}
+
+func (v *Verifier) verifyMinisign(message []byte, signature []byte) (string, error) {
+	var sig minisign.Signature
+	if err := sig.UnmarshalText(signature); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	for _, key := range v.Keys.Minisign {
+		if key.ID() != sig.KeyID {
+			continue
+		}
+
+		if !minisign.Verify(key, message, signature) {
+			return "", ErrInvalidSignature
+		}
+
+		return strings.ToUpper(strconv.FormatUint(key.ID(), 16)), nil
+	}
+
+	return "", fmt.Errorf("%w: %X", ErrUntrustedKey, sig.KeyID)
+}
+
+func (v *Verifier) verifySSH(message []byte, signature []byte) (string, error) {
+	sig, err := ParseSSHSignature(signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	if sig.Namespace != v.namespace() {
+		return "", fmt.Errorf("%w: expected namespace '%s' but found '%s'", ErrInvalidSignature, v.namespace(), sig.Namespace)
+	}
+
+	marshaled := sig.PublicKey.Marshal()
+
+	for _, key := range v.Keys.SSH {
+		if string(key.Marshal()) != string(marshaled) {
+			continue
+		}
+
+		if err := sig.Verify(message); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+
+		return ssh.FingerprintSHA256(key), nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUntrustedKey, ssh.FingerprintSHA256(sig.PublicKey))
+}
@@ -0,0 +1,339 @@
+package trust_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	fan "github.com/joshmeranda/fan/pkg"
+	"github.com/joshmeranda/fan/pkg/trust"
+	"github.com/stretchr/testify/assert"
+)
+
+var script = []byte("#!/usr/bin/env bash\nexit 0")
+
+func writeScript(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "script")
+	assert.NoError(t, os.WriteFile(path, script, 0o755))
+
+	return path
+}
+
+func fetch(t *testing.T, verifier *trust.Verifier, path string) (fan.Target, error) {
+	t.Helper()
+
+	registry := fan.NewRegistry()
+	registry.Register("file", &fan.FileFetcher{})
+	registry.SetVerifier(verifier)
+	verifier.Registry = registry
+
+	target := fan.Target{Url: "file://" + path}
+	err := registry.Fetch(&target, filepath.Join(t.TempDir(), "script"))
+
+	return target, err
+}
+
+func TestMinisign(t *testing.T) {
+	pub, priv, err := minisign.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	encoded, err := pub.MarshalText()
+	assert.NoError(t, err)
+
+	keys, err := trust.ParseKeys([]string{string(encoded)})
+	assert.NoError(t, err)
+
+	t.Run("Valid", func(t *testing.T) {
+		path := writeScript(t)
+		assert.NoError(t, os.WriteFile(path+".minisig", minisign.Sign(priv, script), 0o644))
+
+		target, err := fetch(t, &trust.Verifier{Keys: keys, RequireSignature: true}, path)
+		assert.NoError(t, err)
+		assert.NotNil(t, target.Signature)
+		assert.Equal(t, trust.FormatMinisign, target.Signature.Format)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		path := writeScript(t)
+		assert.NoError(t, os.WriteFile(path+".minisig", minisign.Sign(priv, []byte("something else")), 0o644))
+
+		_, err := fetch(t, &trust.Verifier{Keys: keys}, path)
+		assert.ErrorIs(t, err, trust.ErrInvalidSignature)
+	})
+
+	t.Run("Untrusted", func(t *testing.T) {
+		_, other, err := minisign.GenerateKey(rand.Reader)
+		assert.NoError(t, err)
+
+		path := writeScript(t)
+		assert.NoError(t, os.WriteFile(path+".minisig", minisign.Sign(other, script), 0o644))
+
+		_, err = fetch(t, &trust.Verifier{Keys: keys}, path)
+		assert.ErrorIs(t, err, trust.ErrUntrustedKey)
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		path := writeScript(t)
+
+		target, err := fetch(t, &trust.Verifier{Keys: keys}, path)
+		assert.NoError(t, err)
+		assert.Nil(t, target.Signature)
+
+		_, err = fetch(t, &trust.Verifier{Keys: keys, RequireSignature: true}, path)
+		assert.ErrorIs(t, err, trust.ErrUnsigned)
+	})
+}
+
+func TestSSH(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	dir := t.TempDir()
+	key := filepath.Join(dir, "id_ed25519")
+	assert.NoError(t, exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key).Run())
+
+	keys, err := trust.ParseKeys([]string{key + ".pub"})
+	assert.NoError(t, err)
+
+	path := writeScript(t)
+	assert.NoError(t, exec.Command("ssh-keygen", "-Y", "sign", "-q", "-n", "file", "-f", key, path).Run())
+
+	t.Run("Valid", func(t *testing.T) {
+		target, err := fetch(t, &trust.Verifier{Keys: keys, RequireSignature: true}, path)
+		assert.NoError(t, err)
+		assert.NotNil(t, target.Signature)
+		assert.Equal(t, trust.FormatSSH, target.Signature.Format)
+	})
+
+	t.Run("WrongNamespace", func(t *testing.T) {
+		_, err := fetch(t, &trust.Verifier{Keys: keys, SSHNamespace: "other"}, path)
+		assert.ErrorIs(t, err, trust.ErrInvalidSignature)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte("#!/usr/bin/env bash\nexit 1"), 0o755))
+
+		_, err := fetch(t, &trust.Verifier{Keys: keys}, path)
+		assert.ErrorIs(t, err, trust.ErrInvalidSignature)
+	})
+}
+
+func TestOpenPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("fan", "", "fan@example.com", nil)
+	assert.NoError(t, err)
+
+	keyring := filepath.Join(t.TempDir(), "keyring.asc")
+
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(w))
+	assert.NoError(t, w.Close())
+	assert.NoError(t, os.WriteFile(keyring, buf.Bytes(), 0o644))
+
+	keys := trust.Keys{}
+	keys.OpenPGP, err = trust.LoadKeyring(keyring)
+	assert.NoError(t, err)
+
+	sign := func(t *testing.T, path string, signer *openpgp.Entity) {
+		var signature bytes.Buffer
+		assert.NoError(t, openpgp.ArmoredDetachSign(&signature, signer, bytes.NewReader(script), nil))
+		assert.NoError(t, os.WriteFile(path+".asc", signature.Bytes(), 0o644))
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		path := writeScript(t)
+		sign(t, path, entity)
+
+		target, err := fetch(t, &trust.Verifier{Keys: keys, RequireSignature: true}, path)
+		assert.NoError(t, err)
+		assert.NotNil(t, target.Signature)
+		assert.Equal(t, trust.FormatOpenPGP, target.Signature.Format)
+		assert.Equal(t, fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), target.Signature.KeyID)
+	})
+
+	t.Run("Untrusted", func(t *testing.T) {
+		other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+		assert.NoError(t, err)
+
+		path := writeScript(t)
+		sign(t, path, other)
+
+		_, err = fetch(t, &trust.Verifier{Keys: keys}, path)
+		assert.ErrorIs(t, err, trust.ErrUntrustedKey)
+	})
+}
+
+func TestSigFormats(t *testing.T) {
+	pub, priv, err := minisign.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	entity, err := openpgp.NewEntity("fan", "", "fan@example.com", nil)
+	assert.NoError(t, err)
+
+	keys := trust.Keys{
+		Minisign: []minisign.PublicKey{pub},
+		OpenPGP:  openpgp.EntityList{entity},
+	}
+
+	t.Run("Minisign", func(t *testing.T) {
+		path := writeScript(t)
+		assert.NoError(t, os.WriteFile(path+".sig", minisign.Sign(priv, script), 0o644))
+
+		target, err := fetch(t, &trust.Verifier{Keys: keys, RequireSignature: true}, path)
+		assert.NoError(t, err)
+		assert.Equal(t, trust.FormatMinisign, target.Signature.Format)
+	})
+
+	t.Run("BinaryOpenPGP", func(t *testing.T) {
+		path := writeScript(t)
+
+		var signature bytes.Buffer
+		assert.NoError(t, openpgp.DetachSign(&signature, entity, bytes.NewReader(script), nil))
+		assert.NoError(t, os.WriteFile(path+".sig", signature.Bytes(), 0o644))
+
+		target, err := fetch(t, &trust.Verifier{Keys: keys, RequireSignature: true}, path)
+		assert.NoError(t, err)
+		assert.Equal(t, trust.FormatOpenPGP, target.Signature.Format)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		path := writeScript(t)
+		assert.NoError(t, os.WriteFile(path+".sig", []byte("MEUCIQDx3c0yKZ2vB5nmNWM1V6vSbPQ6O2ZcdKWqvk2xjYwl3wIgU0tX\n"), 0o644))
+
+		target, err := fetch(t, &trust.Verifier{Keys: keys}, path)
+		assert.NoError(t, err)
+		assert.Nil(t, target.Signature)
+
+		_, err = fetch(t, &trust.Verifier{Keys: keys, RequireSignature: true}, path)
+		assert.ErrorIs(t, err, trust.ErrUnsigned)
+		assert.ErrorContains(t, err, "is not a minisign, ssh, or openpgp signature")
+	})
+}
+
+func TestSignatureFetchErrors(t *testing.T) {
+	pub, _, err := minisign.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	keys := trust.Keys{Minisign: []minisign.PublicKey{pub}}
+
+	failing := ".minisig"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/script":
+			w.Write(script)
+		case strings.HasSuffix(r.URL.Path, failing):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	verify := func(verifier *trust.Verifier) error {
+		registry := fan.NewRegistry()
+		registry.Register("http", &fan.HTTPFetcher{})
+		registry.SetVerifier(verifier)
+		verifier.Registry = registry
+
+		return registry.Fetch(&fan.Target{Url: server.URL + "/script"}, filepath.Join(t.TempDir(), "script"))
+	}
+
+	t.Run("ServerError", func(t *testing.T) {
+		err := verify(&trust.Verifier{Keys: keys, RequireSignature: true})
+		assert.ErrorContains(t, err, "failed to fetch signature")
+		assert.NotErrorIs(t, err, trust.ErrUnsigned)
+
+		assert.Error(t, verify(&trust.Verifier{Keys: keys}))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		failing = ".none"
+
+		assert.NoError(t, verify(&trust.Verifier{Keys: keys}))
+		assert.ErrorIs(t, verify(&trust.Verifier{Keys: keys, RequireSignature: true}), trust.ErrUnsigned)
+	})
+}
+
+func TestSignatureS3Denied(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	pub, _, err := minisign.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	keys := trust.Keys{Minisign: []minisign.PublicKey{pub}}
+
+	// s3 denies access to missing keys rather than reporting them missing when the caller may not list the bucket
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bucket/script" {
+			w.Write(script)
+			return
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	verify := func(verifier *trust.Verifier) error {
+		registry := fan.NewRegistry()
+		registry.Register("s3", &fan.S3Fetcher{Region: "us-east-1", Endpoint: server.URL})
+		registry.SetVerifier(verifier)
+		verifier.Registry = registry
+
+		return registry.Fetch(&fan.Target{Url: "s3://bucket/script"}, filepath.Join(t.TempDir(), "script"))
+	}
+
+	assert.NoError(t, verify(&trust.Verifier{Keys: keys}))
+	assert.ErrorIs(t, verify(&trust.Verifier{Keys: keys, RequireSignature: true}), trust.ErrUnsigned)
+}
+
+// scriptFetcher writes script for any target, and records the urls it was asked to fetch.
+type scriptFetcher struct {
+	urls []string
+}
+
+func (f *scriptFetcher) Fetch(target *fan.Target, path string) error {
+	f.urls = append(f.urls, target.Url)
+	return os.WriteFile(path, script, 0o755)
+}
+
+func TestSignatureUnsignableScheme(t *testing.T) {
+	pub, _, err := minisign.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	keys := trust.Keys{Minisign: []minisign.PublicKey{pub}}
+
+	verify := func(verifier *trust.Verifier) ([]string, error) {
+		fetcher := &scriptFetcher{}
+
+		registry := fan.NewRegistry()
+		registry.Register("oci", fetcher)
+		registry.SetVerifier(verifier)
+		verifier.Registry = registry
+
+		err := registry.Fetch(&fan.Target{Url: "oci://registry.example.com/tools/script:latest"}, filepath.Join(t.TempDir(), "script"))
+		return fetcher.urls, err
+	}
+
+	urls, err := verify(&trust.Verifier{Keys: keys})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"oci://registry.example.com/tools/script:latest"}, urls)
+
+	_, err = verify(&trust.Verifier{Keys: keys, RequireSignature: true})
+	assert.ErrorIs(t, err, trust.ErrUnsigned)
+}